@@ -0,0 +1,10 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+import "fmt"
+
+// Keyboard controls are only implemented for Unix terminals
+func enableCbreak(fd int) error {
+	return fmt.Errorf("keyboard controls are not supported on this platform")
+}
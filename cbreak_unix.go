@@ -0,0 +1,20 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// Put the terminal into cbreak mode: keypresses are delivered immediately
+// and not echoed, and Ctrl-C arrives as a key instead of a signal. Output
+// processing stays on so "\n" still starts a new line.
+func enableCbreak(fd int) error {
+	termios, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return err
+	}
+
+	termios.Lflag &^= unix.ICANON | unix.ECHO | unix.ISIG
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(fd, ioctlWriteTermios, termios)
+}
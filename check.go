@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+)
+
+// A problem found by "gmfa check"
+type checkProblem struct {
+	line    int
+	name    string
+	message string
+	warning bool // Suspicious but still usable
+}
+
+// Parse every line of the secrets file and report every problem with it.
+// Returns an error if any line can't be used.
+func checkSecrets(filename string) error {
+	problems, checked, err := findProblems(filename)
+	if err != nil {
+		return err
+	}
+
+	errors, warnings := 0, 0
+	for _, problem := range problems {
+		kind := "error"
+		if problem.warning {
+			kind = "warning"
+			warnings++
+		} else {
+			errors++
+		}
+		fmt.Printf("%s:%d: %s: %s: %s\n", filename, problem.line, kind, problem.name, problem.message)
+	}
+
+	fmt.Printf("Checked %d entries in %s: %d errors, %d warnings\n", checked, filename, errors, warnings)
+	if errors > 0 {
+		return fmt.Errorf("%s has %d problems", filename, errors)
+	}
+	return nil
+}
+
+// The problems in a secrets file, sorted by line, and the number of entries
+// checked: invalid URLs and secrets, unsupported algorithms, digits and
+// periods, unreadable includes, and (as warnings) short secrets,
+// duplicates, conflicting names and shared secrets
+func findProblems(filename string) ([]checkProblem, int, error) {
+	data, err := readSecretsFile(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	type parsedLine struct {
+		line  int
+		entry gmfa.TOTPEntry
+	}
+	var problems []checkProblem
+	var parsed []parsedLine
+	checked := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if include, ok := gmfa.ParseInclude(line); ok {
+			if _, err := loadSecrets(includePath(filename, include), []string{absPath(filename)}); err != nil {
+				problems = append(problems, checkProblem{line: lineNumber, name: gmfa.IncludeDirective + " " + include, message: err.Error()})
+			}
+			continue
+		}
+
+		checked++
+		rawURL, _ := gmfa.SplitComment(line)
+		entry, err := gmfa.ParseOTPAuthURL(rawURL)
+		if err != nil {
+			problems = append(problems, checkProblem{line: lineNumber, name: labelOf(rawURL), message: err.Error()})
+			continue
+		}
+		if err := unsealEntry(&entry); err != nil {
+			problems = append(problems, checkProblem{line: lineNumber, name: entry.Name, message: "can't decrypt its secret: " + err.Error()})
+			continue
+		}
+		parsed = append(parsed, parsedLine{lineNumber, entry})
+
+		if gmfa.ShortSecret(entry) {
+			problems = append(problems, checkProblem{line: lineNumber, name: entry.Name, message: "suspiciously short secret; check it wasn't truncated", warning: true})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// Compare each entry with the earlier ones
+	for i, current := range parsed {
+		key, _ := codeStreamKey(current.entry)
+		for _, earlier := range parsed[:i] {
+			if earlier.entry.Name != current.entry.Name {
+				if earlierKey, _ := codeStreamKey(earlier.entry); key != "" && earlierKey == key {
+					message := fmt.Sprintf("same secret as %s on line %d; both always show the same code", earlier.entry.Name, earlier.line)
+					problems = append(problems, checkProblem{line: current.line, name: current.entry.Name, message: message, warning: true})
+					break
+				}
+				continue
+			}
+			message := fmt.Sprintf("same name as line %d with a different secret", earlier.line)
+			if earlier.entry.Secret == current.entry.Secret {
+				message = fmt.Sprintf("duplicate of line %d", earlier.line)
+			}
+			problems = append(problems, checkProblem{line: current.line, name: current.entry.Name, message: message, warning: true})
+			break
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].line < problems[j].line })
+	return problems, checked, nil
+}
+
+// Best-effort label of a line that failed to parse, for the report
+func labelOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Path == "" {
+		return "(unnamed)"
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
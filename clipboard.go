@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+)
+
+// Clipboard helpers to try, in order, for each platform
+var clipboardCommands = map[string][][]string{
+	"darwin":  {{"pbcopy"}},
+	"windows": {{"clip"}},
+	"linux": {
+		{"wl-copy"},
+		{"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"},
+	},
+}
+
+// Place text on the system clipboard using the first available helper
+func copyToClipboard(text string) error {
+	candidates, ok := clipboardCommands[runtime.GOOS]
+	if !ok {
+		candidates = clipboardCommands["linux"] // Other Unixes use the same tools
+	}
+
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate[0]); err != nil {
+			continue
+		}
+		// wl-copy only works inside a Wayland session
+		if candidate[0] == "wl-copy" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			continue
+		}
+
+		cmd := exec.Command(candidate[0], candidate[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s failed: %v", candidate[0], err)
+		}
+		return nil
+	}
+
+	var names []string
+	for _, candidate := range candidates {
+		names = append(names, candidate[0])
+	}
+	return fmt.Errorf("no clipboard tool found (install one of: %s)", strings.Join(names, ", "))
+}
+
+// Copy the current code of the entry matching name to the clipboard
+func copyCode(filename string, name string) error {
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+
+	i, err := findEntry(entries, name)
+	if err != nil {
+		return err
+	}
+
+	code, err := gmfa.GenerateTOTP(entries[i], now())
+	if err != nil {
+		return fmt.Errorf("%s: %v", entries[i].Name, err)
+	}
+	if err := copyToClipboard(code); err != nil {
+		return err
+	}
+	if err := advanceHOTPCounters(filename, entries[i:i+1]); err != nil {
+		return fmt.Errorf("failed to save HOTP counter: %v", err)
+	}
+
+	fmt.Printf("Copied code for %s to the clipboard\n", displayName(entries[i]))
+	return nil
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+)
+
+// Shortest secret "gmfa new" will generate. RFC 4226 recommends 160 bits;
+// 80 is the least many services still issue.
+const minSecretBytes = 10
+
+// How add, import and -merge resolve a name already taken by a different
+// secret, set with -on-conflict: keep the existing entry, replace its
+// secret, keep both, or ask when empty
+const (
+	collisionAsk     = ""
+	collisionKeep    = "keep"
+	collisionReplace = "replace"
+	collisionBoth    = "both"
+)
+
+// Check an -on-conflict value
+func validCollisionPolicy(policy string) error {
+	switch policy {
+	case collisionAsk, collisionKeep, collisionReplace, collisionBoth:
+		return nil
+	}
+	return fmt.Errorf("invalid -on-conflict value %q (want keep, replace or both)", policy)
+}
+
+// Parse an otpauth URL and append it to the secrets file, resolving a name
+// that is already taken according to policy
+func addEntry(filename string, inputURL string, policy string) error {
+	entry, err := gmfa.ParseOTPAuthURL(inputURL)
+	if err != nil {
+		return err
+	}
+	return appendEntry(filename, entry, policy)
+}
+
+// Append entry to the secrets file, resolving a name that is already taken
+// according to policy
+func appendEntry(filename string, entry gmfa.TOTPEntry, policy string) error {
+	unlock, err := lockSecrets(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := readSecrets(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if hasEntry(entries, entry) {
+		fmt.Printf("Already present: %s\n", entry.Name)
+		return nil
+	}
+	action := collisionBoth
+	if existing := entryNamed(entries, entry.Name); existing != nil {
+		action, err = resolveNameCollision(entry, policy)
+		if err != nil {
+			return err
+		}
+		switch action {
+		case collisionKeep:
+			fmt.Printf("Skipping %s; an entry with that name already exists\n", entry.Name)
+			return nil
+		case collisionReplace:
+			replaceSecret(existing, entry)
+		}
+	}
+	if action == collisionBoth {
+		entries = append(entries, entry)
+	}
+	if err := saveSecrets(filename, entries); err != nil {
+		return err
+	}
+
+	if action == collisionReplace {
+		fmt.Printf("Replaced: %s\n", entry.Name)
+	} else {
+		fmt.Printf("Added: %s\n", entry.Name)
+	}
+	return nil
+}
+
+// Decide what to do about entry taking the name of an entry with a
+// different secret: what policy says, or else what the user answers. With
+// no terminal to ask on, the caller has to choose with -on-conflict.
+func resolveNameCollision(entry gmfa.TOTPEntry, policy string) (string, error) {
+	if policy != collisionAsk {
+		return policy, nil
+	}
+	if !isTerminal(os.Stdin) {
+		return "", fmt.Errorf("an entry named %q already exists with a different secret; re-run with -on-conflict keep, replace or both", entry.Name)
+	}
+
+	fmt.Printf("An entry named %q already exists with a different secret.\n", entry.Name)
+	fmt.Print("[k]eep the existing one, [r]eplace its secret, or keep [b]oth? [k] ")
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		fmt.Println()
+		return collisionKeep, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(scanner.Text())) {
+	case "r", "replace":
+		return collisionReplace, nil
+	case "b", "both":
+		return collisionBoth, nil
+	}
+	return collisionKeep, nil
+}
+
+// Give existing the secret and code parameters of replacement, keeping the
+// favorite mark, tags and comment the user gave it
+func replaceSecret(existing *gmfa.TOTPEntry, replacement gmfa.TOTPEntry) {
+	replacement.Fav = existing.Fav || replacement.Fav
+	if len(replacement.Tags) == 0 {
+		replacement.Tags = existing.Tags
+	}
+	if replacement.Comment == "" {
+		replacement.Comment = existing.Comment
+	}
+	*existing = replacement
+}
+
+// Generate a random secret of secretBytes bytes, base32-encoded with or
+// without "=" padding
+func generateSecret(secretBytes int, unpadded bool) (string, error) {
+	if secretBytes < minSecretBytes {
+		return "", fmt.Errorf("secret must be at least %d bytes", minSecretBytes)
+	}
+
+	secret := make([]byte, secretBytes)
+	defer clear(secret)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %v", err)
+	}
+
+	encoding := base32.StdEncoding
+	if unpadded {
+		encoding = encoding.WithPadding(base32.NoPadding)
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// Create an entry for label with a random secret of secretBytes bytes and
+// print its provisioning URL and first code, optionally saving it with
+// policy for a name that is already taken
+func newEntry(filename string, label string, secretBytes int, unpadded bool, save bool, policy string) error {
+	if strings.TrimSpace(label) == "" {
+		return fmt.Errorf("a label such as Service:me@example.com is required")
+	}
+	secret, err := generateSecret(secretBytes, unpadded)
+	if err != nil {
+		return err
+	}
+
+	entry := gmfa.TOTPEntry{
+		Name:   label,
+		Secret: secret,
+		Type:   "totp",
+	}
+	if issuer, _, found := strings.Cut(label, ":"); found {
+		entry.Params = url.Values{"issuer": {strings.TrimSpace(issuer)}}
+	}
+	gmfa.SplitLabel(&entry)
+	provisioningURL := gmfa.EntryURL(entry)
+
+	// Show and save the entry as read back from the URL, so the code is the
+	// one an authenticator given the URL will show
+	entry, err = gmfa.ParseOTPAuthURL(provisioningURL)
+	if err != nil {
+		return err
+	}
+	code, err := gmfa.GenerateTOTP(entry, now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(provisioningURL)
+	fmt.Printf("Current code: %s\n", code)
+
+	if !save {
+		return nil
+	}
+	return appendEntry(filename, entry, policy)
+}
+
+// Print entry names, one per line
+func listEntries(filename string) error {
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		fmt.Println(entry.Name)
+	}
+	return nil
+}
+
+// Remove the single entry matching name, after confirmation unless yes
+func removeEntry(filename string, name string, yes bool) error {
+	unlock, err := lockSecrets(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+
+	i, err := findEntry(entries, name)
+	if err != nil {
+		return err
+	}
+	if entries[i].Include != "" {
+		return fmt.Errorf("%s comes from %s, included by %s; remove it there (gmfa -config %s)", entries[i].Name, entries[i].Include, filename, entries[i].Include)
+	}
+
+	if !yes && !confirm(fmt.Sprintf("Remove %s?", entries[i].Name)) {
+		return fmt.Errorf("not removed")
+	}
+
+	removed := entries[i]
+	entries = append(entries[:i], entries[i+1:]...)
+	if err := saveSecrets(filename, entries); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed: %s\n", removed.Name)
+	return nil
+}
+
+// Rename the single entry matching oldName to newName
+func renameEntry(filename string, oldName string, newName string) error {
+	if strings.TrimSpace(newName) == "" {
+		return fmt.Errorf("new name must not be empty")
+	}
+
+	unlock, err := lockSecrets(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+
+	i, err := findEntry(entries, oldName)
+	if err != nil {
+		return err
+	}
+	if existing := entryNamed(entries, newName); existing != nil && existing != &entries[i] {
+		fmt.Printf("Warning: another entry is already named %s\n", newName)
+	}
+
+	renamed := entries[i].Name
+	entries[i].Name = newName
+	gmfa.SplitLabel(&entries[i])
+	if err := saveSecrets(filename, entries); err != nil {
+		return err
+	}
+
+	fmt.Printf("Renamed: %s -> %s\n", renamed, newName)
+	return nil
+}
+
+// Interactively move entries of the secrets file around, then save them in
+// the new order. The file order becomes the display order, so explicit
+// order parameters are dropped.
+func reorderEntries(filename string) error {
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("reorder is interactive and stdin is not a terminal")
+	}
+
+	unlock, err := lockSecrets(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+
+	// Entries from included files can only be reordered in those files
+	var own, included []gmfa.TOTPEntry
+	for _, entry := range entries {
+		if entry.Include == "" {
+			own = append(own, entry)
+		} else {
+			included = append(included, entry)
+		}
+	}
+	if len(own) < 2 {
+		return fmt.Errorf("nothing to reorder in %s", filename)
+	}
+	sortByOrder(own)
+
+	// One scanner for every answer, so typed-ahead lines aren't lost
+	scanner := bufio.NewScanner(os.Stdin)
+	readAnswer := func(question string) string {
+		fmt.Print(question)
+		if !scanner.Scan() {
+			fmt.Println()
+			return ""
+		}
+		return strings.TrimSpace(scanner.Text())
+	}
+	readPosition := func(question string) (int, bool) {
+		answer := readAnswer(question)
+		if answer == "" {
+			return 0, false
+		}
+		n, err := strconv.Atoi(answer)
+		if err != nil || n < 1 || n > len(own) {
+			fmt.Printf("Enter a number from 1 to %d\n", len(own))
+			return 0, true
+		}
+		return n, true
+	}
+
+	for {
+		fmt.Println()
+		for i, entry := range own {
+			fmt.Printf("%2d. %s\n", i+1, displayName(entry))
+		}
+
+		from, ok := readPosition("Move entry number (empty to finish): ")
+		if !ok {
+			break
+		}
+		if from == 0 {
+			continue
+		}
+		to, ok := readPosition(fmt.Sprintf("New position for %s: ", displayName(own[from-1])))
+		if !ok || to == 0 {
+			continue
+		}
+
+		moved := own[from-1]
+		own = append(own[:from-1], own[from:]...)
+		own = append(own[:to-1], append([]gmfa.TOTPEntry{moved}, own[to-1:]...)...)
+	}
+
+	if answer := strings.ToLower(readAnswer("Save this order? [y/N] ")); answer != "y" && answer != "yes" {
+		return fmt.Errorf("order not saved")
+	}
+	for i := range own {
+		own[i].Order = 0
+	}
+	if err := saveSecrets(filename, append(own, included...)); err != nil {
+		return err
+	}
+	if len(included) > 0 {
+		fmt.Printf("Entries from included files keep their places; reorder them in those files\n")
+	}
+	return nil
+}
+
+// Ask a yes/no question on stdin; anything but "y" or "yes" means no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		fmt.Println()
+		return false
+	}
+
+	answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+)
+
+func TestGenerateSecretRoundTrips(t *testing.T) {
+	for _, secretBytes := range []int{minSecretBytes, 11, 20, 32} {
+		for _, unpadded := range []bool{false, true} {
+			secret, err := generateSecret(secretBytes, unpadded)
+			if err != nil {
+				t.Fatalf("generateSecret(%d, %v): %v", secretBytes, unpadded, err)
+			}
+			if unpadded && strings.Contains(secret, "=") {
+				t.Errorf("generateSecret(%d, true) = %q, want no padding", secretBytes, secret)
+			}
+			if !unpadded && len(secret)%8 != 0 {
+				t.Errorf("generateSecret(%d, false) = %q, want padding to a multiple of 8", secretBytes, secret)
+			}
+
+			entry, err := gmfa.ParseOTPAuthURL("otpauth://totp/Example:me?secret=" + secret + "&issuer=Example")
+			if err != nil {
+				t.Fatalf("parsing secret %q: %v", secret, err)
+			}
+			decoded, err := gmfa.DecodeSecret(entry)
+			if err != nil {
+				t.Fatalf("decoding secret %q: %v", secret, err)
+			}
+			if len(decoded) != secretBytes {
+				t.Errorf("secret %q decodes to %d bytes, want %d", secret, len(decoded), secretBytes)
+			}
+			if _, err := gmfa.GenerateTOTP(entry, time.Unix(59, 0)); err != nil {
+				t.Errorf("generating a code from secret %q: %v", secret, err)
+			}
+		}
+	}
+}
+
+func TestGenerateSecretTooShort(t *testing.T) {
+	if _, err := generateSecret(minSecretBytes-1, false); err == nil {
+		t.Errorf("generateSecret(%d, false) succeeded, want an error", minSecretBytes-1)
+	}
+}
+
+func TestRenameEntryAmbiguous(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "gmfa.conf")
+	content := "otpauth://totp/GitHub?secret=JBSWY3DPEHPK3PXP\n" +
+		"otpauth://totp/GitHub?secret=GEZDGNBVGY3TQOJQ\n"
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := renameEntry(filename, "GitHub", "GitHub:work")
+	if err == nil || !strings.Contains(err.Error(), "names 2 entries") {
+		t.Fatalf("renameEntry with two entries named GitHub: error = %v, want an ambiguity error", err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("secrets file changed to:\n%s", data)
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Subcommands offered by shell completion, and those whose first argument
+// is an entry name
+var (
+	subcommands     = []string{"verify", "import", "add", "new", "qr", "rename", "export", "list", "status", "remove", "reorder", "check", "doctor", "seal", "completion"}
+	nameSubcommands = []string{"verify", "qr", "rename", "status", "remove"}
+)
+
+// Shells that gmfa completion can generate a script for
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// Flags grouped by what their argument completes to
+type completionFlags struct {
+	all   []string // Every flag
+	value []string // Flags that take an argument
+	name  []string // Flags whose argument is an entry name
+	file  []string // Flags whose argument is a file
+
+	usage map[string]string // Usage text by flag, for shells showing descriptions
+}
+
+// Classify the registered flags by the placeholder in their usage text
+func collectCompletionFlags(fs *flag.FlagSet) completionFlags {
+	kinds := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		kind, _ := flag.UnquoteUsage(f)
+		kinds[f.Name] = kind
+	})
+
+	flags := completionFlags{usage: make(map[string]string)}
+	fs.VisitAll(func(f *flag.Flag) {
+		flags.all = append(flags.all, f.Name)
+		_, flags.usage[f.Name] = flag.UnquoteUsage(f)
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			return
+		}
+		flags.value = append(flags.value, f.Name)
+
+		kind := kinds[f.Name]
+		if target, ok := strings.CutPrefix(f.Usage, "shorthand for -"); ok {
+			kind = kinds[target]
+		}
+		switch kind {
+		case "NAME":
+			flags.name = append(flags.name, f.Name)
+		case "FILE", "PATH":
+			flags.file = append(flags.file, f.Name)
+		}
+	})
+	return flags
+}
+
+// Print a completion script for shell. Entry names are completed by calling
+// back into "gmfa list", honoring any -config on the command line.
+func printCompletion(shell string) error {
+	flags := collectCompletionFlags(flag.CommandLine)
+
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion(flags)
+	default:
+		return fmt.Errorf("unsupported shell %q (want one of: %s)", shell, strings.Join(completionShells, ", "))
+	}
+
+	replacer := strings.NewReplacer(
+		"@FLAGS@", strings.Join(dashed(flags.all, "-"), " "),
+		"@VALUE_FLAGS@", caseAlternatives(flags.value),
+		"@NAME_FLAGS@", caseAlternatives(flags.name),
+		"@FILE_FLAGS@", caseAlternatives(flags.file),
+		"@SUBCOMMANDS@", strings.Join(subcommands, " "),
+		"@NAME_SUBCOMMANDS@", strings.Join(nameSubcommands, "|"),
+		"@SHELLS@", strings.Join(completionShells, " "),
+	)
+	fmt.Print(replacer.Replace(script))
+	return nil
+}
+
+// Prefix each flag name with dashes
+func dashed(names []string, dashes string) []string {
+	prefixed := make([]string, len(names))
+	for i, name := range names {
+		prefixed[i] = dashes + name
+	}
+	return prefixed
+}
+
+// A case pattern matching each flag with one or two dashes
+func caseAlternatives(names []string) string {
+	return strings.Join(append(dashed(names, "-"), dashed(names, "--")...), "|")
+}
+
+// Quote s as a single-quoted fish string
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+const bashCompletion = `# bash completion for gmfa
+# Load with: source <(gmfa completion bash)
+
+_gmfa_names() {
+    local name quoted
+    while IFS= read -r name; do
+        if [[ $name == "${cur//\\/}"* ]]; then
+            printf -v quoted %q "$name"
+            COMPREPLY+=("$quoted")
+        fi
+    done < <("${words[0]}" "${config[@]}" list </dev/null 2>/dev/null)
+}
+
+_gmfa() {
+    local cur prev words cword
+    if declare -F _get_comp_words_by_ref >/dev/null; then
+        _get_comp_words_by_ref -n : cur prev words cword
+    else
+        cur=${COMP_WORDS[COMP_CWORD]}
+        prev=${COMP_WORDS[COMP_CWORD-1]}
+        words=("${COMP_WORDS[@]}")
+        cword=$COMP_CWORD
+    fi
+    COMPREPLY=()
+
+    local -a config=() positional=()
+    local i
+    for ((i = 1; i < cword; i++)); do
+        case ${words[i]} in
+            -c|--c|-config|--config)
+                config=(-config "${words[i+1]}")
+                ((i++))
+                ;;
+            -c=*|--c=*|-config=*|--config=*)
+                config=("${words[i]}")
+                ;;
+            @VALUE_FLAGS@)
+                ((i++))
+                ;;
+            -*)
+                ;;
+            *)
+                positional+=("${words[i]}")
+                ;;
+        esac
+    done
+
+    case $prev in
+        @NAME_FLAGS@)
+            _gmfa_names
+            ;;
+        @FILE_FLAGS@)
+            COMPREPLY=($(compgen -f -- "$cur"))
+            ;;
+        @VALUE_FLAGS@)
+            ;;
+        *)
+            if [[ $cur == -* ]]; then
+                COMPREPLY=($(compgen -W "@FLAGS@" -- "$cur"))
+            elif ((${#positional[@]} == 0)); then
+                COMPREPLY=($(compgen -W "@SUBCOMMANDS@" -- "$cur"))
+                _gmfa_names
+            elif ((${#positional[@]} == 1)); then
+                case ${positional[0]} in
+                    @NAME_SUBCOMMANDS@)
+                        _gmfa_names
+                        ;;
+                    completion)
+                        COMPREPLY=($(compgen -W "@SHELLS@" -- "$cur"))
+                        ;;
+                esac
+            fi
+            ;;
+    esac
+
+    if declare -F __ltrim_colon_completions >/dev/null; then
+        __ltrim_colon_completions "$cur"
+    fi
+}
+
+complete -F _gmfa gmfa
+`
+
+const zshCompletion = `#compdef gmfa
+# zsh completion for gmfa
+# Load with: source <(gmfa completion zsh)
+# or save as _gmfa in a directory on $fpath
+
+_gmfa_names() {
+    local -a names
+    names=(${(f)"$(${words[1]} "${config[@]}" list </dev/null 2>/dev/null)"})
+    compadd -a names
+}
+
+_gmfa() {
+    local -a config positional
+    local i
+    for ((i = 2; i < CURRENT; i++)); do
+        case ${words[i]} in
+            (-c|--c|-config|--config)
+                config=(-config "${words[i+1]}")
+                ((i++))
+                ;;
+            (-c=*|--c=*|-config=*|--config=*)
+                config=("${words[i]}")
+                ;;
+            (@VALUE_FLAGS@)
+                ((i++))
+                ;;
+            (-*)
+                ;;
+            (*)
+                positional+=("${words[i]}")
+                ;;
+        esac
+    done
+
+    case ${words[CURRENT-1]} in
+        (@NAME_FLAGS@)
+            _gmfa_names
+            ;;
+        (@FILE_FLAGS@)
+            _files
+            ;;
+        (@VALUE_FLAGS@)
+            ;;
+        (*)
+            if [[ $PREFIX == -* ]]; then
+                compadd -- @FLAGS@
+            elif ((${#positional} == 0)); then
+                compadd -- @SUBCOMMANDS@
+                _gmfa_names
+            elif ((${#positional} == 1)); then
+                case ${positional[1]} in
+                    (@NAME_SUBCOMMANDS@)
+                        _gmfa_names
+                        ;;
+                    (completion)
+                        compadd -- @SHELLS@
+                        ;;
+                esac
+            fi
+            ;;
+    esac
+}
+
+if [[ $funcstack[1] == _gmfa ]]; then
+    _gmfa "$@"
+else
+    compdef _gmfa gmfa
+fi
+`
+
+// The fish script declares each flag individually, with its usage as the
+// description
+func fishCompletion(flags completionFlags) string {
+	var b strings.Builder
+	b.WriteString(`# fish completion for gmfa
+# Load with: gmfa completion fish | source
+
+# Print the -config arguments given on the command line
+function __gmfa_config
+    set -l words (commandline -opc)
+    for i in (seq 2 (count $words))
+        switch $words[$i]
+            case -c --c -config --config
+                if test $i -lt (count $words)
+                    echo -config
+                    echo $words[(math $i + 1)]
+                end
+            case '-c=*' '--c=*' '-config=*' '--config=*'
+                echo $words[$i]
+        end
+    end
+end
+
+# Print the positional arguments so far; fails if the token being completed
+# is a flag's argument
+function __gmfa_positional
+    set -l skip 0
+    for word in (commandline -opc)[2..-1]
+        if test $skip -eq 1
+            set skip 0
+            continue
+        end
+        switch $word
+            case @VALUE_FLAGS@
+                set skip 1
+            case '-*'
+            case '*'
+                echo $word
+        end
+    end
+    test $skip -eq 0
+end
+
+function __gmfa_names
+    set -l gmfa (commandline -opc)[1]
+    $gmfa (__gmfa_config) list </dev/null 2>/dev/null
+end
+
+function __gmfa_wants_subcommand
+    set -l positional (__gmfa_positional); or return 1
+    test (count $positional) -eq 0
+end
+
+function __gmfa_wants_name
+    set -l positional (__gmfa_positional); or return 1
+    test (count $positional) -eq 0; and return 0
+    test (count $positional) -eq 1; and contains -- $positional[1] @NAME_SUBCOMMANDS@
+end
+
+function __gmfa_wants_shell
+    set -l positional (__gmfa_positional); or return 1
+    test (count $positional) -eq 1; and test $positional[1] = completion
+end
+
+complete -c gmfa -f
+complete -c gmfa -n __gmfa_wants_subcommand -a '@SUBCOMMANDS@'
+complete -c gmfa -n __gmfa_wants_name -a '(__gmfa_names)'
+complete -c gmfa -n __gmfa_wants_shell -a '@SHELLS@'
+`)
+
+	value := make(map[string]bool)
+	for _, name := range flags.value {
+		value[name] = true
+	}
+	kind := make(map[string]string)
+	for _, name := range flags.name {
+		kind[name] = "name"
+	}
+	for _, name := range flags.file {
+		kind[name] = "file"
+	}
+
+	for _, name := range flags.all {
+		line := "complete -c gmfa -o " + name
+		switch {
+		case kind[name] == "name":
+			line += " -x -a '(__gmfa_names)'"
+		case kind[name] == "file":
+			line += " -r -F"
+		case value[name]:
+			line += " -x"
+		}
+		b.WriteString(line + " -d " + fishQuote(flags.usage[name]) + "\n")
+	}
+
+	// fish lists alternatives separated by spaces rather than "|"
+	return strings.NewReplacer(
+		"@VALUE_FLAGS@", strings.ReplaceAll(caseAlternatives(flags.value), "|", " "),
+		"@NAME_SUBCOMMANDS@", strings.Join(nameSubcommands, " "),
+	).Replace(b.String())
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+)
+
+// How often a regular control file is polled for newly appended commands
+const controlPollInterval = 500 * time.Millisecond
+
+// Run headless, executing commands read from a control file or named pipe
+// against the loaded vault. Supported commands, one per line:
+//
+//	code NAME   print the current code for NAME
+//	copy NAME   copy the current code for NAME to the clipboard
+//	list        print all entry names
+//	reload      re-read the secrets file
+//	quit        stop reading commands and exit
+func runControl(path string, secretFile string) error {
+	entries, err := readSecrets(secretFile)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	isPipe := info.Mode()&os.ModeNamedPipe != 0
+
+	fmt.Printf("Loaded %d MFA entries; reading commands from %s\n", len(entries), path)
+
+	for {
+		// Opening a named pipe blocks until a writer connects
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		progress := &controlProgress{digest: sha256.New()}
+		if !isPipe {
+			// Only act on commands appended after startup
+			if err := progress.skip(file); err != nil {
+				file.Close()
+				return err
+			}
+		}
+
+		reader := bufio.NewReader(file)
+		quit := false
+		var pending string
+		for !quit {
+			chunk, err := reader.ReadString('\n')
+			pending += chunk
+			progress.add(chunk)
+			if err == io.EOF {
+				if isPipe {
+					break // Writer closed; reopen and wait for the next one
+				}
+				time.Sleep(controlPollInterval)
+
+				// Start over on a file rewritten rather than appended to
+				rewritten, err := progress.rewritten(file)
+				if err == nil && rewritten {
+					_, err = file.Seek(0, io.SeekStart)
+					progress.offset = 0
+					progress.digest.Reset()
+					reader.Reset(file)
+					pending = ""
+				}
+				if err != nil {
+					file.Close()
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				file.Close()
+				return err
+			}
+
+			quit = handleControlCommand(strings.TrimSpace(pending), &entries, secretFile)
+			pending = ""
+		}
+		file.Close()
+
+		if quit {
+			return nil
+		}
+	}
+}
+
+// What has been read of a regular control file, to tell commands appended
+// to it from the file being rewritten (e.g. with ">")
+type controlProgress struct {
+	offset  int64
+	digest  hash.Hash // SHA-256 of the bytes read so far
+	modTime time.Time
+}
+
+// Read past the file's current contents
+func (p *controlProgress) skip(file *os.File) error {
+	n, err := io.Copy(p.digest, file)
+	if err != nil {
+		return err
+	}
+	p.offset = n
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	p.modTime = info.ModTime()
+	return nil
+}
+
+// Record bytes read from the file
+func (p *controlProgress) add(chunk string) {
+	p.offset += int64(len(chunk))
+	p.digest.Write([]byte(chunk))
+}
+
+// Report whether the file no longer starts with what was read of it: it
+// shrank, or changed without only growing
+func (p *controlProgress) rewritten(file *os.File) (bool, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() < p.offset {
+		return true, nil
+	}
+	if info.ModTime().Equal(p.modTime) {
+		return false, nil
+	}
+	p.modTime = info.ModTime()
+
+	current := sha256.New()
+	if _, err := io.Copy(current, io.NewSectionReader(file, 0, p.offset)); err != nil {
+		return false, err
+	}
+	return !bytes.Equal(current.Sum(nil), p.digest.Sum(nil)), nil
+}
+
+// Execute a single control command, reporting whether the loop should stop
+func handleControlCommand(line string, entries *[]gmfa.TOTPEntry, secretFile string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return false
+	}
+
+	command, args := strings.ToLower(fields[0]), fields[1:]
+	switch {
+	case command == "code" && len(args) == 1:
+		i, err := findEntry(*entries, args[0])
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			return false
+		}
+		entry := &(*entries)[i]
+		code, err := gmfa.GenerateTOTP(*entry, now())
+		if err != nil {
+			fmt.Printf("error: %s: %v\n", entry.Name, err)
+			return false
+		}
+		fmt.Printf("%s %s\n", entry.Name, code)
+		if gmfa.IsHOTP(*entry) {
+			if err := advanceHOTPCounters(secretFile, []gmfa.TOTPEntry{*entry}); err != nil {
+				fmt.Printf("error: failed to save HOTP counter: %v\n", err)
+				return false
+			}
+			entry.Counter++
+		}
+
+	case command == "copy" && len(args) == 1:
+		i, err := findEntry(*entries, args[0])
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			return false
+		}
+		entry := &(*entries)[i]
+		code, err := gmfa.GenerateTOTP(*entry, now())
+		if err == nil {
+			err = copyToClipboard(code)
+		}
+		if err != nil {
+			fmt.Printf("error: %s: %v\n", entry.Name, err)
+			return false
+		}
+		if gmfa.IsHOTP(*entry) {
+			if err := advanceHOTPCounters(secretFile, []gmfa.TOTPEntry{*entry}); err != nil {
+				fmt.Printf("error: failed to save HOTP counter: %v\n", err)
+				return false
+			}
+			entry.Counter++
+		}
+		fmt.Printf("ok: copied %s\n", entry.Name)
+
+	case command == "list" && len(args) == 0:
+		for _, entry := range *entries {
+			fmt.Println(entry.Name)
+		}
+
+	case command == "reload" && len(args) == 0:
+		reloaded, err := readSecrets(secretFile)
+		if err != nil {
+			fmt.Printf("error: reload failed, keeping %d loaded entries: %v\n", len(*entries), err)
+			return false
+		}
+		*entries = reloaded
+		fmt.Printf("ok: reloaded %d entries\n", len(reloaded))
+
+	case (command == "quit" || command == "exit") && len(args) == 0:
+		fmt.Println("ok: bye")
+		return true
+
+	default:
+		fmt.Printf("error: malformed command %q\n", line)
+	}
+
+	return false
+}
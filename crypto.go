@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// An encrypted secrets file is this header line followed by one line of
+// base64(salt || nonce || AES-256-GCM ciphertext of the plaintext file)
+const encryptedHeader = "GMFA-ENCRYPTED-V1\n"
+
+// scrypt parameters for deriving the file key from the passphrase
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptSalt   = 16
+	scryptKeyLen = 32
+)
+
+// Passphrases of the encrypted files read so far, by absolute path,
+// remembered so each can be re-read and re-encrypted in the same run. Files
+// not in here are written in plaintext.
+var vaultPassphrases = make(map[string][]byte)
+
+// Environment variable holding the passphrase, for automation
+const passphraseEnv = "GMFA_PASSPHRASE"
+
+// Shell command printing the passphrase (e.g. from a password manager),
+// set with -passphrase-command
+var passphraseCommand string
+
+// Report whether file contents are an encrypted secrets file
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedHeader))
+}
+
+// Report whether the file on disk is an encrypted secrets file
+func isEncryptedFile(filename string) bool {
+	data, err := os.ReadFile(filename)
+	return err == nil && isEncrypted(data)
+}
+
+// Read the secrets file, decrypting it if it is encrypted
+func readSecretsFile(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil || !isEncrypted(data) {
+		return data, err
+	}
+
+	path := absPath(filename)
+	if passphrase := vaultPassphrases[path]; passphrase != nil {
+		return decryptSecrets(data, passphrase)
+	}
+	// An included file is often encrypted like the file including it
+	for _, passphrase := range vaultPassphrases {
+		if plaintext, err := decryptSecrets(data, passphrase); err == nil {
+			vaultPassphrases[path] = passphrase
+			return plaintext, nil
+		}
+	}
+
+	passphrase, err := readPassphrase(fmt.Sprintf("Passphrase for %s: ", filename))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := decryptSecrets(data, passphrase)
+	if err != nil {
+		clear(passphrase)
+		return nil, err
+	}
+	vaultPassphrases[path] = passphrase
+	return plaintext, nil
+}
+
+// Get the passphrase from $GMFA_PASSPHRASE, else from -passphrase-command,
+// else by prompting on the terminal
+func readPassphrase(prompt string) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(passphraseEnv); ok {
+		// Keep it from commands we run, like clipboard helpers
+		os.Unsetenv(passphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("$%s is empty", passphraseEnv)
+		}
+		return []byte(passphrase), nil
+	}
+	if passphraseCommand != "" {
+		return commandPassphrase(passphraseCommand)
+	}
+	return promptPassphrase(prompt)
+}
+
+// Run a shell command and take the first line of its output as the
+// passphrase. Its stderr and stdin stay connected so it can prompt.
+func commandPassphrase(command string) ([]byte, error) {
+	shell, flag := "/bin/sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+
+	cmd := exec.Command(shell, flag, command)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	defer clear(output)
+	if err != nil {
+		// Don't echo the command; it may hold secrets of its own
+		return nil, fmt.Errorf("-passphrase-command failed: %v", err)
+	}
+
+	line, _, _ := bytes.Cut(output, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if len(line) == 0 {
+		return nil, fmt.Errorf("-passphrase-command printed no passphrase")
+	}
+	return bytes.Clone(line), nil
+}
+
+// Encrypt the plaintext secrets file contents with a passphrase
+func encryptSecrets(plaintext []byte, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, scryptSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newVaultCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := append(append(salt, nonce...), gcm.Seal(nil, nonce, plaintext, nil)...)
+	return []byte(encryptedHeader + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+// Decrypt encrypted secrets file contents with a passphrase
+func decryptSecrets(data []byte, passphrase []byte) ([]byte, error) {
+	encoded := bytes.TrimSpace(bytes.TrimPrefix(data, []byte(encryptedHeader)))
+	sealed, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("corrupted encrypted secrets file: %v", err)
+	}
+	if len(sealed) < scryptSalt {
+		return nil, fmt.Errorf("corrupted encrypted secrets file: too short")
+	}
+
+	salt, rest := sealed[:scryptSalt], sealed[scryptSalt:]
+	gcm, err := newVaultCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < gcm.NonceSize() {
+		return nil, fmt.Errorf("corrupted encrypted secrets file: too short")
+	}
+
+	nonce, ciphertext := rest[:gcm.NonceSize()], rest[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted secrets file")
+	}
+	return plaintext, nil
+}
+
+// Derive the file key and set up AES-GCM
+func newVaultCipher(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key) // The cipher keeps its own expanded copy
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Read a passphrase from the terminal without echoing it
+func promptPassphrase(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("the secrets file is encrypted and stdin is not a terminal to read the passphrase from")
+	}
+
+	// ReadPassword turns echo off; turn it back on if Ctrl-C or a kill
+	// ends the program at the prompt
+	state, err := term.GetState(fd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	defer func() {
+		signal.Stop(signals)
+		close(done)
+	}()
+	go func() {
+		select {
+		case <-signals:
+			term.Restore(fd, state)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	return passphrase, nil
+}
+
+// Get the passphrase to encrypt with: from $GMFA_PASSPHRASE or
+// -passphrase-command when set, else typed twice on the terminal
+func newPassphrase() ([]byte, error) {
+	if _, ok := os.LookupEnv(passphraseEnv); ok || passphraseCommand != "" {
+		return readPassphrase("")
+	}
+
+	passphrase, err := promptPassphrase("New passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	confirm, err := promptPassphrase("Repeat passphrase: ")
+	defer clear(confirm)
+	if err != nil {
+		clear(passphrase)
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, confirm) {
+		clear(passphrase)
+		return nil, fmt.Errorf("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// Convert a plaintext secrets file to an encrypted one
+func encryptSecretsFile(filename string) error {
+	unlock, err := lockSecrets(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if isEncrypted(data) {
+		return fmt.Errorf("%s is already encrypted", filename)
+	}
+
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+	count := len(ownEntries(entries)) // Included files stay as they are
+	if dryRun {
+		fmt.Printf("Dry run: would encrypt %d entries in %s\n", count, filename)
+		return nil
+	}
+
+	passphrase, err := newPassphrase()
+	if err != nil {
+		return err
+	}
+
+	vaultPassphrases[absPath(filename)] = passphrase
+	if err := writeSecrets(filename, entries); err != nil {
+		return err
+	}
+
+	fmt.Printf("Encrypted %d MFA entries in %s\n", count, filename)
+	warnPlaintextBackups(filename)
+	return nil
+}
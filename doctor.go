@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+)
+
+// How serious a "gmfa doctor" finding is, most serious first
+const (
+	findingError = iota
+	findingWarning
+	findingOK
+)
+
+// Something "gmfa doctor" found, with a suggested fix for problems
+type finding struct {
+	severity int
+	message  string
+	fix      string
+}
+
+// Run every setup check at once: where the secrets file is and where that
+// choice came from, its permissions, every entry in it and, with
+// checkClock, the clock's drift against driftURL. Prints the findings most
+// serious first and returns an error if any of them is an error.
+func runDoctor(filename string, configFlag string, checkClock bool, driftURL string) error {
+	var findings []finding
+	add := func(severity int, message string, fix string) {
+		findings = append(findings, finding{severity, message, fix})
+	}
+
+	fmt.Printf("Secrets file: %s (%s)\n", filename, configSource(filename, configFlag))
+	if legacy := legacyConfigPath(); configFlag == "" && os.Getenv("GMFA_CONFIG") == "" && legacy != "" && legacy != filename && fileExists(legacy) {
+		add(findingWarning, fmt.Sprintf("%s exists but is ignored in favor of %s", legacy, filename),
+			fmt.Sprintf("run gmfa -merge %s, then remove %s", legacy, legacy))
+	}
+
+	info, err := os.Stat(filename)
+	switch {
+	case os.IsNotExist(err):
+		add(findingError, fmt.Sprintf("%s does not exist", filename),
+			"add entries with gmfa add URL or gmfa import, or point -config or $GMFA_CONFIG at your secrets file")
+	case err != nil:
+		add(findingError, fmt.Sprintf("%s can't be read: %v", filename, err), "")
+	default:
+		if runtime.GOOS != "windows" && info.Mode().Perm()&^0600 != 0 {
+			add(findingWarning, fmt.Sprintf("%s has mode %04o and may be readable by other users", filename, info.Mode().Perm()),
+				"run gmfa -fix-perms, or chmod 600 "+filename)
+		} else if runtime.GOOS != "windows" {
+			add(findingOK, "permissions are restricted to you", "")
+		}
+
+		problems, checked, err := findProblems(filename)
+		if err != nil {
+			add(findingError, fmt.Sprintf("%s can't be read: %v", filename, err), "")
+			break
+		}
+		errors, warnings := 0, 0
+		for _, problem := range problems {
+			if problem.warning {
+				warnings++
+			} else {
+				errors++
+			}
+		}
+		switch {
+		case errors > 0:
+			add(findingError, fmt.Sprintf("%d of %d entries can't be used", errors, checked), "run gmfa check for details")
+		case checked == 0:
+			add(findingWarning, "the secrets file has no entries", "add entries with gmfa add URL or gmfa import")
+		default:
+			add(findingOK, fmt.Sprintf("all %d entries parse", checked), "")
+		}
+		if warnings > 0 {
+			add(findingWarning, fmt.Sprintf("%d entries look suspicious (duplicates, shared or short secrets)", warnings), "run gmfa check for details")
+		}
+	}
+
+	if checkClock {
+		skew, err := httpDateOffset(driftURL)
+		limit := time.Duration(gmfa.DefaultPeriod) * time.Second / 2
+		switch {
+		case err != nil:
+			add(findingWarning, fmt.Sprintf("clock check against %s failed: %v", driftURL, err), "check the network connection, or choose another server with -drift-url")
+		case skew.Abs() > limit:
+			add(findingError, fmt.Sprintf("the clock is off by about %+.1fs, enough for servers to reject codes", skew.Seconds()),
+				fmt.Sprintf("sync the system clock (e.g. enable NTP), or run with -ntp or -offset %d", int64(skew.Round(time.Second).Seconds())))
+		default:
+			add(findingOK, fmt.Sprintf("the clock is off by about %+.1fs", skew.Seconds()), "")
+		}
+	} else {
+		add(findingOK, "the clock was not checked; run gmfa doctor -check-drift to compare it with "+driftURL, "")
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].severity < findings[j].severity })
+	errors, warnings := 0, 0
+	for _, f := range findings {
+		label := "ok"
+		switch f.severity {
+		case findingError:
+			label = styled(consoleBold+consoleRed, "error")
+			errors++
+		case findingWarning:
+			label = styled(consoleBold+consoleYellow, "warning")
+			warnings++
+		}
+		fmt.Printf("%s: %s\n", label, f.message)
+		if f.fix != "" {
+			fmt.Printf("    fix: %s\n", f.fix)
+		}
+	}
+
+	fmt.Printf("Found %d errors and %d warnings\n", errors, warnings)
+	if errors > 0 {
+		return fmt.Errorf("%d problems need fixing", errors)
+	}
+	return nil
+}
+
+// Describe why filename is the secrets file in use
+func configSource(filename string, configFlag string) string {
+	switch {
+	case configFlag != "":
+		return "from -config"
+	case os.Getenv("GMFA_CONFIG") != "":
+		return "from $GMFA_CONFIG"
+	case filename == legacyConfigPath() && os.Getenv("XDG_CONFIG_HOME") != "":
+		return "legacy location, used while it exists instead of $XDG_CONFIG_HOME"
+	case filename == legacyConfigPath():
+		return "default"
+	default:
+		return "from $XDG_CONFIG_HOME"
+	}
+}
+
+// The secrets file used before XDG_CONFIG_HOME was supported, or "" if the
+// home directory is unknown
+func legacyConfigPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, configFile)
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+)
+
+// How long to wait for the -check-drift server to answer
+const driftTimeout = 5 * time.Second
+
+// Estimate how far the local clock is off from the Date header of an HTTP
+// HEAD response. The header has one second resolution, so the estimate is
+// only good to about half a second. A positive offset means the local clock
+// is behind.
+func httpDateOffset(url string) (time.Duration, error) {
+	client := &http.Client{Timeout: driftTimeout}
+
+	sent := time.Now()
+	response, err := client.Head(url)
+	received := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+
+	date, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("no usable Date header from %s", url)
+	}
+
+	// The server truncated its clock to the second somewhere between our
+	// request and its response; assume the middle of both
+	midpoint := sent.Add(received.Sub(sent) / 2)
+	return date.Add(time.Second / 2).Sub(midpoint), nil
+}
+
+// Report the local clock's skew against url, warning once it is large
+// enough (half a time step) for codes to start being rejected
+func checkDrift(url string) {
+	skew, err := httpDateOffset(url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: clock check against %s failed: %v\n", url, err)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Local clock is off by about %+.1fs according to %s\n", skew.Seconds(), url)
+	limit := time.Duration(gmfa.DefaultPeriod) * time.Second / 2
+	if skew.Abs() > limit {
+		fmt.Fprintf(os.Stderr, "Warning: skew over %s makes servers reject codes. Sync the system clock (e.g. enable NTP),\n", limit)
+		fmt.Fprintf(os.Stderr, "or run with -ntp or -offset %d to correct for it.\n", int64(skew.Round(time.Second).Seconds()))
+	}
+}
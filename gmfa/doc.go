@@ -0,0 +1,9 @@
+// Package gmfa parses otpauth URLs and secrets files and generates TOTP,
+// HOTP and Steam Guard codes from them.
+//
+//	entry, err := gmfa.ParseOTPAuthURL("otpauth://totp/Example:alice?secret=JBSWY3DPEHPK3PXP")
+//	if err != nil {
+//		return err
+//	}
+//	code, err := gmfa.GenerateTOTP(entry, time.Now())
+package gmfa
@@ -0,0 +1,515 @@
+package gmfa
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Defaults for parameters an otpauth URL leaves out
+const (
+	DefaultPeriod    = 30 // seconds
+	DefaultDigits    = 6
+	DefaultAlgorithm = "SHA1"
+)
+
+// Prefix marking a consumed backup code in the secrets file
+const backupUsedPrefix = "~"
+
+// SealedPrefix marks a secret parameter that is encrypted, e.g.
+// "secret=enc:...". The library keeps such secrets as they are; callers
+// decrypt them and hand the result to SetSecret.
+const SealedPrefix = "enc:"
+
+// TOTPEntry is one account from a secrets file: an otpauth URL broken into
+// its parts.
+type TOTPEntry struct {
+	Name      string       // Label from the URL path, conventionally "Issuer:account"
+	Issuer    string       // Issuer parameter, or the label part before the colon
+	Account   string       // Label part after the colon
+	Secret    string       // Empty while a Sealed secret hasn't been decrypted
+	Sealed    string       // Encrypted "enc:" secret as stored in the file, written back in place of Secret
+	Encoding  string       // Secret encoding: base32 (default when empty), hex or base64
+	Algorithm string       // HMAC hash: SHA1 (default when empty), SHA256 or SHA512
+	Digits    int          // Code length: 6 (default when 0), 7 or 8
+	Period    int64        // Seconds each code is valid for, 30 when 0
+	Epoch     int64        // Unix time the time steps count from (RFC 6238's T0), usually 0
+	Type      string       // "totp" (time-based), "hotp" (counter-based) or "steam"
+	Counter   uint64       // Next HOTP counter value
+	Backup    []BackupCode // Static recovery codes issued alongside the secret
+	Fav       bool         // Pinned to the top of the display
+	Tags      []string     // User labels for grouping and filtering
+	Order     int          // Display position; entries with one (from 1) come first, then the rest in file order
+	Comment   string       // Note from the "#" comment lines above the entry in the secrets file
+	Include   string       // Path of the @include'd secrets file holding the entry; empty for the file itself
+	Params    url.Values   // Other query parameters (issuer, ...) kept for lossless rewrites
+
+	cache *secretCache // Decoded secret, set by ParseOTPAuthURL
+}
+
+// BackupCode is a single static backup (recovery) code and whether it has
+// been consumed.
+type BackupCode struct {
+	Code string
+	Used bool
+}
+
+// ParseOTPAuthURL parses an otpauth://totp, hotp or steam URL into an
+// entry. It is as lenient as the mainstream authenticator apps: the scheme
+// and type are case-insensitive, a stray "%" in the label is taken
+// literally, parameters it doesn't know are kept but otherwise ignored, and
+// a missing label falls back to the issuer.
+func ParseOTPAuthURL(inputURL string) (TOTPEntry, error) {
+	inputURL = strings.TrimSpace(inputURL)
+	u, err := url.Parse(inputURL)
+	if err != nil {
+		// Labels like "Shop 100%" are sometimes left unescaped
+		if fixed, fixErr := url.Parse(escapeStrayPercents(inputURL)); fixErr == nil {
+			u, err = fixed, nil
+		}
+	}
+	if err != nil {
+		return TOTPEntry{}, fmt.Errorf("invalid URL format: %v", err)
+	}
+
+	otpType := strings.ToLower(u.Host)
+	if u.Scheme != "otpauth" || (otpType != "totp" && otpType != "hotp" && otpType != "steam") {
+		return TOTPEntry{}, fmt.Errorf("URL must be an otpauth://totp, otpauth://hotp or otpauth://steam URL")
+	}
+
+	// An encoded "/" in the label ("Foo%2FBar") is part of the name
+	path := strings.TrimPrefix(u.Path, "/")
+	query, queryErr := url.ParseQuery(u.RawQuery)
+
+	secrets := query["secret"]
+	switch {
+	case len(secrets) == 0 && queryErr != nil:
+		return TOTPEntry{}, fmt.Errorf("missing 'secret' parameter in URL (malformed query: %v)", queryErr)
+	case len(secrets) == 0:
+		return TOTPEntry{}, fmt.Errorf("missing 'secret' parameter in URL")
+	case strings.TrimSpace(secrets[0]) == "":
+		return TOTPEntry{}, fmt.Errorf("the 'secret' parameter in the URL is empty")
+	}
+	for _, other := range secrets[1:] {
+		if other != secrets[0] {
+			return TOTPEntry{}, fmt.Errorf("URL has %d conflicting 'secret' parameters", len(secrets))
+		}
+	}
+	secret := secrets[0]
+
+	unlabeled := path == ""
+	if unlabeled {
+		path = query.Get("issuer")
+		if path == "" {
+			return TOTPEntry{}, fmt.Errorf("URL has neither a label nor an issuer to name the entry")
+		}
+	}
+
+	// Code parameters stay zero when absent so a rewrite doesn't add
+	// parameters that weren't in the original URL
+	var algorithm string
+	if value := query.Get("algorithm"); value != "" {
+		algorithm = strings.ReplaceAll(strings.ToUpper(value), "-", "") // Also "SHA-256"
+		if _, ok := hashAlgorithms[algorithm]; !ok {
+			return TOTPEntry{}, fmt.Errorf("unsupported algorithm %q (want SHA1, SHA256 or SHA512)", value)
+		}
+	}
+
+	var digits int
+	if value := query.Get("digits"); value != "" {
+		digits, err = strconv.Atoi(value)
+		if err != nil || digits < 6 || digits > 8 {
+			return TOTPEntry{}, fmt.Errorf("unsupported digits %q (want 6, 7 or 8)", value)
+		}
+	}
+
+	var period int64
+	if value := query.Get("period"); value != "" {
+		period, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || period <= 0 {
+			return TOTPEntry{}, fmt.Errorf("invalid period %q (want a positive number of seconds)", value)
+		}
+	}
+
+	var epoch int64
+	if value := query.Get("epoch"); value != "" {
+		epoch, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || epoch < 0 {
+			return TOTPEntry{}, fmt.Errorf("invalid epoch %q (want a Unix time in seconds)", value)
+		}
+	}
+
+	var order int
+	if value := query.Get("order"); value != "" {
+		order, err = strconv.Atoi(value)
+		if err != nil || order < 1 {
+			return TOTPEntry{}, fmt.Errorf("invalid order %q (want a positive number)", value)
+		}
+	}
+
+	var counter uint64
+	if otpType == "hotp" {
+		value := query.Get("counter")
+		if value == "" {
+			return TOTPEntry{}, fmt.Errorf("missing 'counter' parameter in HOTP URL")
+		}
+		counter, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return TOTPEntry{}, fmt.Errorf("invalid counter %q", value)
+		}
+	}
+
+	entry := TOTPEntry{
+		Name:      path,
+		Algorithm: algorithm,
+		Digits:    digits,
+		Period:    period,
+		Epoch:     epoch,
+		Type:      otpType,
+		Counter:   counter,
+		Backup:    parseBackupCodes(query.Get("backup")),
+		Fav:       query.Get("fav") == "1",
+		Tags:      parseTags(query.Get("tags")),
+		Order:     order,
+	}
+
+	entry.Encoding = strings.ToLower(query.Get("encoding"))
+	if strings.HasPrefix(secret, SealedPrefix) {
+		// Decrypted later by the caller, with SetSecret
+		entry.Secret, entry.Sealed = "", secret
+		if err := checkEncoding(entry.Encoding); err != nil {
+			return TOTPEntry{}, err
+		}
+	} else if err := setSecret(&entry, secret); err != nil {
+		return TOTPEntry{}, err
+	}
+
+	// Keep everything we don't interpret so it survives a rewrite
+	for _, key := range []string{"secret", "encoding", "algorithm", "digits", "period", "epoch", "counter", "backup", "fav", "tags", "order"} {
+		query.Del(key)
+	}
+	if len(query) > 0 {
+		entry.Params = query
+	}
+	SplitLabel(&entry)
+	if unlabeled {
+		entry.Account = "" // The name is just the issuer
+	}
+	entry.cache = newSecretCache(entry)
+
+	return entry, nil
+}
+
+// SetSecret sets the entry's secret, cleaned up and checked according to
+// its encoding, as ParseOTPAuthURL does. It is how a decrypted Sealed
+// secret is put in place; the Sealed form is kept for writing back.
+func SetSecret(entry *TOTPEntry, secret string) error {
+	if err := setSecret(entry, secret); err != nil {
+		return err
+	}
+	entry.cache = newSecretCache(*entry)
+	return nil
+}
+
+// Set the secret for the entry's encoding without updating the cache
+func setSecret(entry *TOTPEntry, secret string) error {
+	switch entry.Encoding {
+	case "", "base32":
+		entry.Secret = normalizeBase32Secret(secret)
+		if _, err := DecodeSecret(*entry); err != nil {
+			// Some tokens hand out hex secrets without saying so
+			if !isHexSecret(stripSpaces(secret)) {
+				return fmt.Errorf("invalid base32 secret: %v", err)
+			}
+			entry.Secret = stripSpaces(secret)
+			entry.Encoding = "hex"
+		}
+	case "hex":
+		entry.Secret = stripSpaces(secret)
+		if _, err := DecodeSecret(*entry); err != nil {
+			return fmt.Errorf("invalid hex secret: %v", err)
+		}
+	case "base64":
+		// The query decoder turns an unescaped "+" into a space
+		entry.Secret = strings.ReplaceAll(strings.TrimSpace(secret), " ", "+")
+		if _, err := DecodeSecret(*entry); err != nil {
+			return fmt.Errorf("invalid base64 secret: %v", err)
+		}
+	default:
+		return checkEncoding(entry.Encoding)
+	}
+	return nil
+}
+
+// Check that a secret encoding is one DecodeSecret understands
+func checkEncoding(encoding string) error {
+	switch encoding {
+	case "", "base32", "hex", "base64":
+		return nil
+	}
+	return fmt.Errorf("unsupported secret encoding %q", encoding)
+}
+
+// EntryURL builds the full otpauth URL for an entry, including every
+// stored parameter.
+func EntryURL(entry TOTPEntry) string {
+	otpType := entry.Type
+	if otpType == "" {
+		otpType = "totp"
+	}
+	// Escape a "/" in the name so it stays part of the label
+	u := url.URL{Scheme: "otpauth", Host: otpType, Path: "/" + entry.Name, RawPath: "/" + url.PathEscape(entry.Name)}
+
+	// Base32 and hex secrets need no escaping; base64 ones may hold "+" and
+	// "/". Sealed secrets are unpadded URL-safe base64.
+	query := "secret=" + entry.Secret
+	if entry.Sealed != "" {
+		query = "secret=" + entry.Sealed
+	} else if entry.Encoding == "base64" {
+		query = "secret=" + url.QueryEscape(entry.Secret)
+	}
+	if entry.Encoding != "" {
+		query += "&encoding=" + entry.Encoding
+	}
+	if entry.Algorithm != "" {
+		query += "&algorithm=" + entry.Algorithm
+	}
+	if entry.Digits != 0 {
+		query += "&digits=" + strconv.Itoa(entry.Digits)
+	}
+	if IsHOTP(entry) {
+		query += "&counter=" + strconv.FormatUint(entry.Counter, 10)
+	} else {
+		if entry.Period != 0 {
+			query += "&period=" + strconv.FormatInt(entry.Period, 10)
+		}
+		if entry.Epoch != 0 {
+			query += "&epoch=" + strconv.FormatInt(entry.Epoch, 10)
+		}
+	}
+	if len(entry.Params) > 0 {
+		query += "&" + entry.Params.Encode()
+	}
+	if len(entry.Backup) > 0 {
+		query += "&backup=" + formatBackupCodes(entry.Backup)
+	}
+	if entry.Fav {
+		query += "&fav=1"
+	}
+	if len(entry.Tags) > 0 {
+		query += "&tags=" + formatTags(entry.Tags)
+	}
+	if entry.Order != 0 {
+		query += "&order=" + strconv.Itoa(entry.Order)
+	}
+	u.RawQuery = query
+
+	return u.String()
+}
+
+// ProvisioningURL builds the otpauth URL to hand to an authenticator app:
+// the secret re-encoded as unpadded base32, whatever its encoding, and only
+// the standard Key URI parameters. gmfa's own bookkeeping, like backup codes
+// and tags, is left out, and so is the epoch, which apps don't support.
+func ProvisioningURL(entry TOTPEntry) (string, error) {
+	secret, err := DecodeSecret(entry)
+	if err != nil {
+		return "", err
+	}
+
+	standard := TOTPEntry{
+		Name:      entry.Name,
+		Secret:    base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret),
+		Algorithm: entry.Algorithm,
+		Digits:    entry.Digits,
+		Period:    entry.Period,
+		Type:      entry.Type,
+		Counter:   entry.Counter,
+	}
+	if issuer := entry.Params.Get("issuer"); issuer != "" {
+		standard.Params = url.Values{"issuer": {issuer}}
+	}
+	return EntryURL(standard), nil
+}
+
+// SplitLabel fills in an entry's Issuer and Account from its
+// "Issuer:account" label, preferring the issuer parameter when present.
+func SplitLabel(entry *TOTPEntry) {
+	issuer, account, found := strings.Cut(entry.Name, ":")
+	if !found {
+		issuer, account = "", entry.Name
+	}
+	entry.Issuer = strings.TrimSpace(issuer)
+	entry.Account = strings.TrimSpace(account)
+
+	if param := entry.Params.Get("issuer"); param != "" {
+		entry.Issuer = param
+	}
+}
+
+// IsHOTP reports whether an entry is counter-based rather than time-based.
+func IsHOTP(entry TOTPEntry) bool {
+	return entry.Type == "hotp"
+}
+
+// IsSteam reports whether an entry produces Steam Guard codes.
+func IsSteam(entry TOTPEntry) bool {
+	return entry.Type == "steam"
+}
+
+// EntryPeriod returns the entry's time step in seconds, defaulting to 30.
+func EntryPeriod(entry TOTPEntry) int64 {
+	if entry.Period == 0 {
+		return DefaultPeriod
+	}
+	return entry.Period
+}
+
+// ValidUntil returns the Unix time at which the entry's code at Unix time
+// timestamp expires. Meaningless for HOTP entries.
+func ValidUntil(entry TOTPEntry, timestamp int64) int64 {
+	period := EntryPeriod(entry)
+	elapsed := ((timestamp-entry.Epoch)%period + period) % period
+	return timestamp + period - elapsed
+}
+
+// AlgorithmName returns the entry's HMAC algorithm name, defaulting to
+// SHA1.
+func AlgorithmName(entry TOTPEntry) string {
+	if entry.Algorithm == "" {
+		return DefaultAlgorithm
+	}
+	return entry.Algorithm
+}
+
+// DigitCount returns the entry's code length, defaulting to 6 digits.
+func DigitCount(entry TOTPEntry) int {
+	if IsSteam(entry) {
+		return steamCodeLength
+	}
+	if entry.Digits == 0 {
+		return DefaultDigits
+	}
+	return entry.Digits
+}
+
+// DecodeSecret decodes an entry's secret into the raw HMAC key according
+// to its encoding.
+func DecodeSecret(entry TOTPEntry) ([]byte, error) {
+	if entry.Secret == "" && entry.Sealed != "" {
+		return nil, fmt.Errorf("secret is encrypted and hasn't been decrypted")
+	}
+	switch entry.Encoding {
+	case "hex":
+		return hex.DecodeString(entry.Secret)
+	case "base64":
+		// Accept URL-safe and unpadded forms too
+		secret := strings.NewReplacer("-", "+", "_", "/").Replace(strings.TrimRight(entry.Secret, "="))
+		return base64.RawStdEncoding.DecodeString(secret)
+	}
+	return base32.StdEncoding.DecodeString(strings.ToUpper(entry.Secret))
+}
+
+// Escape each "%" that doesn't start a valid percent-encoded byte
+func escapeStrayPercents(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && (i+2 >= len(s) || !isHexDigit(s[i+1]) || !isHexDigit(s[i+2])) {
+			b.WriteString("%25")
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// Clean up a pasted base32 secret: drop whitespace, uppercase it and
+// restore the "=" padding that is often left off
+func normalizeBase32Secret(secret string) string {
+	secret = strings.ToUpper(stripSpaces(secret))
+	secret = strings.TrimRight(secret, "=")
+	if rem := len(secret) % 8; rem != 0 {
+		secret += strings.Repeat("=", 8-rem)
+	}
+	return secret
+}
+
+// Remove all whitespace from a secret
+func stripSpaces(secret string) string {
+	return strings.Join(strings.Fields(secret), "")
+}
+
+// Report whether a secret looks like an even-length hex string
+func isHexSecret(secret string) bool {
+	if len(secret)%2 != 0 {
+		return false
+	}
+	_, err := hex.DecodeString(secret)
+	return err == nil
+}
+
+// Parse a comma-separated backup code list; used codes carry a "~" prefix
+func parseBackupCodes(value string) []BackupCode {
+	var codes []BackupCode
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		used := strings.HasPrefix(field, backupUsedPrefix)
+		field = strings.TrimPrefix(field, backupUsedPrefix)
+		if field == "" {
+			continue
+		}
+		codes = append(codes, BackupCode{Code: field, Used: used})
+	}
+	return codes
+}
+
+// Format backup codes for the "backup" URL parameter
+func formatBackupCodes(codes []BackupCode) string {
+	fields := make([]string, len(codes))
+	for i, bc := range codes {
+		field := url.QueryEscape(bc.Code)
+		if bc.Used {
+			field = backupUsedPrefix + field
+		}
+		fields[i] = field
+	}
+	return strings.Join(fields, ",")
+}
+
+// HasTag reports whether the entry carries tag, ignoring case.
+func HasTag(entry TOTPEntry, tag string) bool {
+	for _, t := range entry.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse a comma-separated tag list
+func parseTags(value string) []string {
+	var tags []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			tags = append(tags, field)
+		}
+	}
+	return tags
+}
+
+// Format tags for the "tags" URL parameter
+func formatTags(tags []string) string {
+	fields := make([]string, len(tags))
+	for i, tag := range tags {
+		fields[i] = url.QueryEscape(tag)
+	}
+	return strings.Join(fields, ",")
+}
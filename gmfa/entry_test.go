@@ -0,0 +1,132 @@
+package gmfa
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// The RFC 6238 SHA1 secret given as hex, explicitly and auto-detected
+func TestDecodeHexSecret(t *testing.T) {
+	want := []byte("12345678901234567890")
+	for _, rawURL := range []string{
+		"otpauth://totp/Hex?secret=3132333435363738393031323334353637383930&encoding=hex&digits=8",
+		"otpauth://totp/Hex?secret=3132333435363738393031323334353637383930&digits=8",
+	} {
+		entry := mustParse(t, rawURL)
+		if entry.Encoding != "hex" {
+			t.Errorf("%s: encoding %q, want hex", rawURL, entry.Encoding)
+		}
+
+		secret, err := DecodeSecret(entry)
+		if err != nil {
+			t.Fatalf("%s: DecodeSecret: %v", rawURL, err)
+		}
+		if !bytes.Equal(secret, want) {
+			t.Errorf("%s: DecodeSecret = %q, want %q", rawURL, secret, want)
+		}
+
+		code, err := GenerateTOTP(entry, time.Unix(59, 0))
+		if err != nil {
+			t.Fatalf("%s: GenerateTOTP: %v", rawURL, err)
+		}
+		if code != "94287082" {
+			t.Errorf("%s: GenerateTOTP at 59 = %s, want 94287082", rawURL, code)
+		}
+	}
+}
+
+// Weird but valid URLs as real services and apps produce them
+func TestParseOTPAuthURLLenient(t *testing.T) {
+	tests := []struct {
+		url     string
+		name    string
+		issuer  string
+		account string
+	}{
+		{"otpauth://totp/ACME%20Co:john.doe@email.com?secret=HXDMVJECJJWSRB3HWIZR4IFUGFTMXBOZ&issuer=ACME%20Co&algorithm=SHA1&digits=6&period=30", "ACME Co:john.doe@email.com", "ACME Co", "john.doe@email.com"},
+		{"otpauth://totp/Amazon%3Ame%40example.com?secret=JBSWY3DPEHPK3PXP&issuer=Amazon", "Amazon:me@example.com", "Amazon", "me@example.com"},
+		{"otpauth://totp/GitHub:octocat?secret=JBSWY3DPEHPK3PXP&issuer=GitHub&image=https%3A%2F%2Fexample.com%2Flogo.png&color=ff0000", "GitHub:octocat", "GitHub", "octocat"},
+		{"OTPAUTH://TOTP/Upper?secret=JBSWY3DPEHPK3PXP", "Upper", "", "Upper"},
+		{"otpauth://totp/Shop%20100%?secret=JBSWY3DPEHPK3PXP", "Shop 100%", "", "Shop 100%"},
+		{"otpauth://totp/Foo%2FBar:me?secret=JBSWY3DPEHPK3PXP", "Foo/Bar:me", "Foo/Bar", "me"},
+		{"otpauth://totp/?secret=JBSWY3DPEHPK3PXP&issuer=NoLabel", "NoLabel", "NoLabel", ""},
+		{"otpauth://totp/Same?secret=JBSWY3DPEHPK3PXP&secret=JBSWY3DPEHPK3PXP", "Same", "", "Same"},
+		{"otpauth://totp/Alg?secret=JBSWY3DPEHPK3PXP&algorithm=sha-256", "Alg", "", "Alg"},
+		{"  otpauth://totp/Spaced?secret=JBSW%20Y3DP%20EHPK%203PXP  ", "Spaced", "", "Spaced"},
+	}
+	for _, test := range tests {
+		entry, err := ParseOTPAuthURL(test.url)
+		if err != nil {
+			t.Errorf("ParseOTPAuthURL(%q): %v", test.url, err)
+			continue
+		}
+		if entry.Name != test.name || entry.Issuer != test.issuer || entry.Account != test.account {
+			t.Errorf("ParseOTPAuthURL(%q) = name %q, issuer %q, account %q; want %q, %q, %q",
+				test.url, entry.Name, entry.Issuer, entry.Account, test.name, test.issuer, test.account)
+		}
+
+		// Writing it back must give the same entry
+		again, err := ParseOTPAuthURL(EntryURL(entry))
+		if err != nil || again.Name != entry.Name || again.Secret != entry.Secret {
+			t.Errorf("%q doesn't round-trip through %q (%v)", test.url, EntryURL(entry), err)
+		}
+	}
+}
+
+func TestParseOTPAuthURLErrors(t *testing.T) {
+	tests := []struct {
+		url string
+		err string
+	}{
+		{"otpauth://totp/None?issuer=x", "missing 'secret' parameter in URL"},
+		{"otpauth://totp/Empty?secret=", "the 'secret' parameter in the URL is empty"},
+		{"otpauth://totp/Two?secret=JBSWY3DPEHPK3PXP&secret=JBSWY3DPEHPK3PXQ", "URL has 2 conflicting 'secret' parameters"},
+		{"otpauth://totp/Semi?secret=JBSWY3DPEHPK3PXP;issuer=x", "missing 'secret' parameter in URL (malformed query: invalid semicolon separator in query)"},
+		{"otpauth://totp/?secret=JBSWY3DPEHPK3PXP", "URL has neither a label nor an issuer to name the entry"},
+		{"https://example.com/?secret=JBSWY3DPEHPK3PXP", "URL must be an otpauth://totp, otpauth://hotp or otpauth://steam URL"},
+		{"otpauth://totp/Alg?secret=JBSWY3DPEHPK3PXP&algorithm=MD5", `unsupported algorithm "MD5" (want SHA1, SHA256 or SHA512)`},
+	}
+	for _, test := range tests {
+		_, err := ParseOTPAuthURL(test.url)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("ParseOTPAuthURL(%q) error = %v, want %q", test.url, err, test.err)
+		}
+	}
+}
+
+// Hex and base64 secrets are handed to apps as base32, without gmfa's own
+// parameters
+func TestProvisioningURL(t *testing.T) {
+	tests := []struct {
+		rawURL string
+		want   string
+	}{
+		{
+			"otpauth://totp/ACME:me?secret=3132333435363738393031323334353637383930&encoding=hex&issuer=ACME&tags=work&order=2&fav=1&backup=one,two",
+			"otpauth://totp/ACME:me?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&issuer=ACME",
+		},
+		{
+			"otpauth://totp/ACME:me?secret=MTIzNDU2Nzg5MDEyMzQ1Njc4OTA%3D&encoding=base64&algorithm=SHA256&digits=8&period=60&epoch=30",
+			"otpauth://totp/ACME:me?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&algorithm=SHA256&digits=8&period=60",
+		},
+		{
+			"otpauth://hotp/Bank?secret=JBSWY3DPEHPK3PXP&counter=7",
+			"otpauth://hotp/Bank?secret=JBSWY3DPEHPK3PXP&counter=7",
+		},
+		{
+			"otpauth://totp/Padded?secret=GEZDGNBVGY3TQOJQGEZA====",
+			"otpauth://totp/Padded?secret=GEZDGNBVGY3TQOJQGEZA",
+		},
+	}
+	for _, tt := range tests {
+		got, err := ProvisioningURL(mustParse(t, tt.rawURL))
+		if err != nil {
+			t.Errorf("ProvisioningURL(%s): %v", tt.rawURL, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ProvisioningURL(%s)\n got %s\nwant %s", tt.rawURL, got, tt.want)
+		}
+	}
+}
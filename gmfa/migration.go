@@ -0,0 +1,295 @@
+package gmfa
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Google Authenticator export ("otpauth-migration://offline?data=...").
+// The data parameter is a base64-encoded protobuf MigrationPayload:
+//
+//	message MigrationPayload {
+//	  repeated OtpParameters otp_parameters = 1;
+//	  ...
+//	}
+//	message OtpParameters {
+//	  bytes secret = 1; string name = 2; string issuer = 3;
+//	  Algorithm algorithm = 4; DigitCount digits = 5; OtpType type = 6;
+//	  int64 counter = 7;
+//	}
+const MigrationScheme = "otpauth-migration"
+
+// Protobuf wire types used by the migration payload
+const (
+	wireVarint = 0
+	wire64Bit  = 1
+	wireBytes  = 2
+	wire32Bit  = 5
+)
+
+// ParseMigrationURL parses an otpauth-migration URL into the entries it
+// contains. Accounts that can't be represented are left out and described
+// in skipped.
+func ParseMigrationURL(inputURL string) (entries []TOTPEntry, skipped []error, err error) {
+	u, err := url.Parse(inputURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid URL format: %v", err)
+	}
+	if u.Scheme != MigrationScheme {
+		return nil, nil, fmt.Errorf("URL must be an %s:// URL", MigrationScheme)
+	}
+
+	data := u.Query().Get("data")
+	if data == "" {
+		return nil, nil, fmt.Errorf("missing 'data' parameter in URL")
+	}
+
+	// Exports are standard base64, but tolerate URL-safe and unpadded forms
+	data = strings.NewReplacer("-", "+", "_", "/", " ", "+").Replace(strings.TrimRight(data, "="))
+	payload, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid migration data: %v", err)
+	}
+
+	err = readProtoFields(payload, func(field int, wireType int, value uint64, raw []byte) error {
+		if field != 1 || wireType != wireBytes {
+			return nil // Version and batch bookkeeping
+		}
+		entry, err := parseMigrationOTP(raw)
+		if err != nil {
+			skipped = append(skipped, err)
+			return nil
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid migration data: %v", err)
+	}
+
+	return entries, skipped, nil
+}
+
+// Convert one OtpParameters message into an entry
+func parseMigrationOTP(message []byte) (TOTPEntry, error) {
+	var secret []byte
+	var name, issuer string
+	var algorithm, digits, otpType, counter uint64
+
+	err := readProtoFields(message, func(field int, wireType int, value uint64, raw []byte) error {
+		switch field {
+		case 1:
+			secret = raw
+		case 2:
+			name = string(raw)
+		case 3:
+			issuer = string(raw)
+		case 4:
+			algorithm = value
+		case 5:
+			digits = value
+		case 6:
+			otpType = value
+		case 7:
+			counter = value
+		}
+		return nil
+	})
+	if err != nil {
+		return TOTPEntry{}, err
+	}
+	if len(secret) == 0 {
+		return TOTPEntry{}, fmt.Errorf("%s: missing secret", name)
+	}
+
+	entry := TOTPEntry{
+		Name:   name,
+		Secret: base32.StdEncoding.EncodeToString(secret),
+		Type:   "totp",
+	}
+	if issuer != "" {
+		entry.Params = url.Values{"issuer": {issuer}}
+		if !strings.HasPrefix(name, issuer+":") {
+			entry.Name = issuer + ":" + name
+		}
+	}
+
+	switch algorithm {
+	case 0, 1:
+		entry.Algorithm = "SHA1"
+	case 2:
+		entry.Algorithm = "SHA256"
+	case 3:
+		entry.Algorithm = "SHA512"
+	default:
+		return TOTPEntry{}, fmt.Errorf("%s: unsupported algorithm (MD5)", entry.Name)
+	}
+
+	switch digits {
+	case 0, 1:
+		entry.Digits = 6
+	case 2:
+		entry.Digits = 8
+	default:
+		return TOTPEntry{}, fmt.Errorf("%s: unsupported digit count", entry.Name)
+	}
+
+	switch otpType {
+	case 0, 2:
+		entry.Period = DefaultPeriod
+	case 1:
+		entry.Type = "hotp"
+		entry.Counter = counter
+	default:
+		return TOTPEntry{}, fmt.Errorf("%s: unsupported OTP type", entry.Name)
+	}
+	SplitLabel(&entry)
+
+	return entry, nil
+}
+
+// MigrationURL encodes entries as a single otpauth-migration URL that
+// Google Authenticator can import. Entries the format can't express (Steam
+// codes, 7 digits, periods other than 30 seconds) are left out and described
+// in skipped.
+func MigrationURL(entries []TOTPEntry) (migrationURL string, skipped []error) {
+	var payload []byte
+	for _, entry := range entries {
+		message, err := migrationOTP(entry)
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("%s: %v", entry.Name, err))
+			continue
+		}
+		payload = appendProtoBytes(payload, 1, message)
+	}
+	payload = appendProtoVarint(payload, 2, 1) // version
+	payload = appendProtoVarint(payload, 3, 1) // batch_size
+	payload = appendProtoVarint(payload, 4, 0) // batch_index
+
+	data := url.QueryEscape(base64.StdEncoding.EncodeToString(payload))
+	return MigrationScheme + "://offline?data=" + data, skipped
+}
+
+// Encode one entry as an OtpParameters message
+func migrationOTP(entry TOTPEntry) ([]byte, error) {
+	if IsSteam(entry) {
+		return nil, fmt.Errorf("Steam Guard entries are not supported")
+	}
+	if !IsHOTP(entry) && EntryPeriod(entry) != DefaultPeriod {
+		return nil, fmt.Errorf("only %d second periods are supported", DefaultPeriod)
+	}
+	if !IsHOTP(entry) && entry.Epoch != 0 {
+		return nil, fmt.Errorf("custom epochs are not supported")
+	}
+
+	secret, err := DecodeSecret(entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret: %v", err)
+	}
+
+	var algorithm uint64
+	switch AlgorithmName(entry) {
+	case "SHA1":
+		algorithm = 1
+	case "SHA256":
+		algorithm = 2
+	case "SHA512":
+		algorithm = 3
+	}
+
+	var digits uint64
+	switch DigitCount(entry) {
+	case 6:
+		digits = 1
+	case 8:
+		digits = 2
+	default:
+		return nil, fmt.Errorf("only 6 and 8 digit codes are supported")
+	}
+
+	otpType := uint64(2)
+	if IsHOTP(entry) {
+		otpType = 1
+	}
+
+	// Authenticator keeps the account name and issuer apart
+	name := entry.Name
+	if entry.Issuer != "" {
+		name = entry.Account
+	}
+
+	var message []byte
+	message = appendProtoBytes(message, 1, secret)
+	message = appendProtoBytes(message, 2, []byte(name))
+	message = appendProtoBytes(message, 3, []byte(entry.Issuer))
+	message = appendProtoVarint(message, 4, algorithm)
+	message = appendProtoVarint(message, 5, digits)
+	message = appendProtoVarint(message, 6, otpType)
+	message = appendProtoVarint(message, 7, entry.Counter)
+	return message, nil
+}
+
+// Append a varint field to a protobuf message
+func appendProtoVarint(message []byte, field int, value uint64) []byte {
+	message = binary.AppendUvarint(message, uint64(field)<<3|wireVarint)
+	return binary.AppendUvarint(message, value)
+}
+
+// Append a length-delimited field to a protobuf message
+func appendProtoBytes(message []byte, field int, value []byte) []byte {
+	message = binary.AppendUvarint(message, uint64(field)<<3|wireBytes)
+	message = binary.AppendUvarint(message, uint64(len(value)))
+	return append(message, value...)
+}
+
+// Walk the fields of a protobuf message, calling fn with each field number,
+// wire type and its value (varints) or raw bytes (length-delimited)
+func readProtoFields(message []byte, fn func(field int, wireType int, value uint64, raw []byte) error) error {
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return fmt.Errorf("malformed field key")
+		}
+		message = message[n:]
+		field, wireType := int(key>>3), int(key&7)
+
+		var value uint64
+		var raw []byte
+		switch wireType {
+		case wireVarint:
+			value, n = binary.Uvarint(message)
+			if n <= 0 {
+				return fmt.Errorf("malformed varint in field %d", field)
+			}
+			message = message[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || length > uint64(len(message)-n) {
+				return fmt.Errorf("malformed length in field %d", field)
+			}
+			raw = message[n : n+int(length)]
+			message = message[n+int(length):]
+		case wire64Bit:
+			if len(message) < 8 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			message = message[8:]
+		case wire32Bit:
+			if len(message) < 4 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			message = message[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d in field %d", wireType, field)
+		}
+
+		if err := fn(field, wireType, value, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package gmfa
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// LineError describes a line of a secrets file that is not a valid otpauth
+// URL.
+type LineError struct {
+	Line int    // 1-based line number
+	Text string // The line as written
+	Err  error
+}
+
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// IncludeDirective starts a secrets file line naming another secrets file
+// whose entries are loaded too: "@include /path/to/other.conf".
+const IncludeDirective = "@include"
+
+// ReadSecrets parses a secrets file: one otpauth URL per line, with blank
+// lines ignored. "#" comment lines directly above an entry, and a comment
+// after its URL, become the entry's Comment; other comments are ignored.
+// The paths of @include lines are returned in includes, as written; loading
+// them is up to the caller. Invalid lines are left out and returned in
+// invalid rather than failing the whole file.
+func ReadSecrets(r io.Reader) (entries []TOTPEntry, includes []string, invalid []LineError, err error) {
+	var comments []string
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			comments = nil // A blank line separates comments from the next entry
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			comments = append(comments, commentText(line))
+			continue
+		}
+		if path, ok := ParseInclude(line); ok {
+			includes = append(includes, path)
+			comments = nil
+			continue
+		}
+
+		line, trailing := SplitComment(line)
+		if trailing != "" {
+			comments = append(comments, trailing)
+		}
+
+		entry, err := ParseOTPAuthURL(line)
+		if err != nil {
+			invalid = append(invalid, LineError{Line: lineNumber, Text: line, Err: err})
+			comments = nil
+			continue
+		}
+		entry.Comment = strings.Join(comments, "\n")
+		comments = nil
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	return entries, includes, invalid, nil
+}
+
+// ParseInclude returns the path named by an @include line.
+func ParseInclude(line string) (path string, ok bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(line), IncludeDirective)
+	if !ok || rest == "" || !unicode.IsSpace(rune(rest[0])) {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// SplitComment separates a secrets file line into the URL and the text of
+// a trailing "# comment", if there is one.
+func SplitComment(line string) (rawURL string, comment string) {
+	// A URL never contains whitespace, so " #" starts a trailing comment
+	if i := strings.IndexFunc(line, unicode.IsSpace); i >= 0 {
+		if rest := strings.TrimSpace(line[i:]); strings.HasPrefix(rest, "#") {
+			return line[:i], commentText(rest)
+		}
+	}
+	return line, ""
+}
+
+// The text of a "#" comment line
+func commentText(line string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(line, "#"), " ")
+}
+
+// WriteSecrets writes entries, after @include lines for includes, in the
+// secrets file format read by ReadSecrets.
+func WriteSecrets(w io.Writer, entries []TOTPEntry, includes []string) error {
+	var content strings.Builder
+
+	// Write header comments
+	content.WriteString("# GMFA Secrets File\n")
+	content.WriteString("# Format: otpauth://totp/Service:user@example.com?secret=ABCDEFGHIJKLMNOP&issuer=Service\n\n")
+
+	if len(includes) > 0 {
+		for _, path := range includes {
+			content.WriteString(IncludeDirective + " " + path + "\n")
+		}
+		content.WriteString("\n")
+	}
+
+	// Write the URLs, each below its comment
+	for _, entry := range entries {
+		if entry.Comment != "" {
+			for _, line := range strings.Split(entry.Comment, "\n") {
+				content.WriteString(strings.TrimRight("# "+line, " ") + "\n")
+			}
+		}
+		content.WriteString(EntryURL(entry) + "\n")
+	}
+
+	_, err := io.WriteString(w, content.String())
+	return err
+}
@@ -0,0 +1,210 @@
+package gmfa
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"sync"
+	"time"
+)
+
+// Steam Guard codes are five characters from this alphabet
+const (
+	steamAlphabet   = "23456789BCDFGHJKMNPQRTVWXY"
+	steamCodeLength = 5
+)
+
+// Hash constructors for the supported otpauth algorithm names
+var hashAlgorithms = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+// Codes remembered per entry: enough for the current and next time steps
+// that a redraw shows
+const maxCachedCodes = 4
+
+// Decoded secret of an entry parsed from a URL, with its recently generated
+// codes, so redrawing every second doesn't decode the secret and recompute
+// the HMAC each time. Copies of the entry share it.
+type secretCache struct {
+	secret   string // Secret and Encoding the key was decoded from
+	encoding string
+	key      []byte
+
+	mu    sync.Mutex
+	codes map[codeKey]string
+}
+
+// Everything besides the key that a code depends on
+type codeKey struct {
+	counter   uint64
+	algorithm string
+	digits    int
+	steam     bool
+}
+
+// Decode the entry's secret once for caching; nil if it doesn't decode
+func newSecretCache(entry TOTPEntry) *secretCache {
+	key, err := DecodeSecret(entry)
+	if err != nil {
+		return nil
+	}
+	return &secretCache{secret: entry.Secret, encoding: entry.Encoding, key: key, codes: make(map[codeKey]string)}
+}
+
+// The entry's cache, unless its secret has changed since it was decoded
+func validCache(entry TOTPEntry) *secretCache {
+	if c := entry.cache; c != nil && c.secret == entry.Secret && c.encoding == entry.Encoding {
+		return c
+	}
+	return nil
+}
+
+// The entry's HMAC key, decoded only if it isn't cached
+func secretKey(entry TOTPEntry) ([]byte, error) {
+	if c := validCache(entry); c != nil {
+		return c.key, nil
+	}
+	return DecodeSecret(entry)
+}
+
+func (c *secretCache) lookup(key codeKey) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	code, ok := c.codes[key]
+	return code, ok
+}
+
+func (c *secretCache) store(key codeKey, code string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.codes) >= maxCachedCodes {
+		clear(c.codes)
+	}
+	c.codes[key] = code
+}
+
+// ShortSecret reports whether the entry's decoded secret is shorter than
+// half the output size of its hash (10 bytes for SHA1, 16 for SHA256, 32 for
+// SHA512). Secrets that short are usually truncated when pasted.
+func ShortSecret(entry TOTPEntry) bool {
+	secret, err := secretKey(entry)
+	newHash, ok := hashAlgorithms[AlgorithmName(entry)]
+	if err != nil || !ok {
+		return false // Reported as an invalid secret instead
+	}
+	return len(secret) < newHash().Size()/2
+}
+
+// GenerateTOTP returns the entry's code at time t. HOTP entries ignore t
+// and use their stored counter.
+func GenerateTOTP(entry TOTPEntry, t time.Time) (string, error) {
+	counterBytes := CounterBytes(entry, t)
+
+	// Reuse the code if this counter's was generated recently
+	cache := validCache(entry)
+	key := codeKey{
+		counter:   binary.BigEndian.Uint64(counterBytes),
+		algorithm: AlgorithmName(entry),
+		digits:    DigitCount(entry),
+		steam:     IsSteam(entry),
+	}
+	if code, ok := cache.lookup(key); ok {
+		return code, nil
+	}
+
+	code, err := generateCode(entry, counterBytes)
+	if err != nil {
+		return "", err
+	}
+	cache.store(key, code)
+	return code, nil
+}
+
+// Compute the entry's code for the HMAC message counterBytes
+func generateCode(entry TOTPEntry, counterBytes []byte) (string, error) {
+	// Decode the shared secret
+	secretBytes, err := secretKey(entry)
+	if err != nil {
+		return "", fmt.Errorf("invalid secret: %v", err)
+	}
+
+	newHash, ok := hashAlgorithms[AlgorithmName(entry)]
+	if !ok {
+		return "", fmt.Errorf("unsupported algorithm %q", entry.Algorithm)
+	}
+
+	// Generate the HMAC with the entry's hash algorithm
+	mac := hmac.New(newHash, secretBytes)
+	mac.Write(counterBytes)
+	hash := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := hash[len(hash)-1] & 0x0F
+	truncatedHash := binary.BigEndian.Uint32(hash[offset:offset+4]) & 0x7FFFFFFF
+
+	if IsSteam(entry) {
+		return steamCode(truncatedHash), nil
+	}
+
+	// Generate code with the required number of digits
+	digits := DigitCount(entry)
+	code := truncatedHash % uint32(pow10(digits))
+	return fmt.Sprintf("%0*d", digits, code), nil
+}
+
+// Encode the TOTP counter (number of time steps since epoch) as the 8-byte
+// big-endian HMAC message
+func totpCounterBytes(timestamp int64, epoch int64, period int64) []byte {
+	// Floored, like ValidUntil, so times before the epoch count down from -1
+	counter := (timestamp - epoch) / period
+	if (timestamp-epoch)%period < 0 {
+		counter--
+	}
+
+	counterBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(counterBytes, uint64(counter))
+	return counterBytes
+}
+
+// CounterBytes returns the HMAC message for an entry at time t: its stored
+// counter for HOTP, or the time step counter otherwise.
+func CounterBytes(entry TOTPEntry, t time.Time) []byte {
+	if IsHOTP(entry) {
+		counterBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(counterBytes, entry.Counter)
+		return counterBytes
+	}
+	return totpCounterBytes(t.Unix(), entry.Epoch, EntryPeriod(entry))
+}
+
+// Map a truncated hash onto Steam Guard's five-character alphabet
+func steamCode(truncatedHash uint32) string {
+	code := make([]byte, steamCodeLength)
+	for i := range code {
+		code[i] = steamAlphabet[truncatedHash%uint32(len(steamAlphabet))]
+		truncatedHash /= uint32(len(steamAlphabet))
+	}
+	return string(code)
+}
+
+// Helper function to calculate 10^n
+func pow10(n int) int {
+	result := 1
+	for i := 0; i < n; i++ {
+		result *= 10
+	}
+	return result
+}
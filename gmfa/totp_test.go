@@ -0,0 +1,103 @@
+package gmfa
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// Parse an otpauth URL, failing the test if it doesn't parse
+func mustParse(t *testing.T, rawURL string) TOTPEntry {
+	t.Helper()
+	entry, err := ParseOTPAuthURL(rawURL)
+	if err != nil {
+		t.Fatalf("ParseOTPAuthURL(%q): %v", rawURL, err)
+	}
+	return entry
+}
+
+// RFC 6238 appendix B: the SHA1 secret is the ASCII "12345678901234567890"
+func TestGenerateTOTPRFC6238(t *testing.T) {
+	entry := mustParse(t, "otpauth://totp/RFC6238?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=8")
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, test := range tests {
+		code, err := GenerateTOTP(entry, time.Unix(test.unix, 0))
+		if err != nil {
+			t.Fatalf("GenerateTOTP at %d: %v", test.unix, err)
+		}
+		if code != test.code {
+			t.Errorf("GenerateTOTP at %d = %s, want %s", test.unix, code, test.code)
+		}
+	}
+}
+
+// Steam Guard codes for the RFC 6238 secret, worked out independently of
+// this package from Steam's truncation and alphabet
+func TestGenerateTOTPSteam(t *testing.T) {
+	entry := mustParse(t, "otpauth://steam/Steam?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "PV9M4"},
+		{1234567890, "VHHQY"},
+	}
+	for _, test := range tests {
+		code, err := GenerateTOTP(entry, time.Unix(test.unix, 0))
+		if err != nil {
+			t.Fatalf("GenerateTOTP at %d: %v", test.unix, err)
+		}
+		if code != test.code {
+			t.Errorf("GenerateTOTP at %d = %s, want %s", test.unix, code, test.code)
+		}
+	}
+}
+
+// An epoch moves the time steps: with T0 = 30, time 89 is step 1, which is
+// RFC 6238's step for time 59
+func TestGenerateTOTPEpoch(t *testing.T) {
+	plain := mustParse(t, "otpauth://totp/RFC6238?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=8")
+	shifted := mustParse(t, "otpauth://totp/RFC6238?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=8&epoch=30")
+
+	code, err := GenerateTOTP(shifted, time.Unix(89, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "94287082" {
+		t.Errorf("GenerateTOTP with epoch 30 at 89 = %s, want 94287082", code)
+	}
+
+	withEpoch, _ := GenerateTOTP(shifted, time.Unix(59, 0))
+	without, _ := GenerateTOTP(plain, time.Unix(59, 0))
+	if withEpoch == without {
+		t.Errorf("epoch 30 didn't change the code at 59 (%s)", withEpoch)
+	}
+}
+
+// The counter changes exactly at ValidUntil, before the epoch too
+func TestCounterMatchesValidUntil(t *testing.T) {
+	entry := mustParse(t, "otpauth://totp/Epoch?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&epoch=1000")
+
+	counter := func(ts int64) uint64 { return binary.BigEndian.Uint64(CounterBytes(entry, time.Unix(ts, 0))) }
+	for ts := int64(900); ts <= 1100; ts++ {
+		until := ValidUntil(entry, ts)
+		if counter(until-1) != counter(ts) {
+			t.Errorf("at %d: counter changes before ValidUntil %d", ts, until)
+		}
+		if counter(until) != counter(ts)+1 {
+			t.Errorf("at %d: counter doesn't advance at ValidUntil %d", ts, until)
+		}
+	}
+}
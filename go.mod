@@ -1,3 +1,10 @@
 module github.com/nealhardesty/gmfa
 
 go 1.24.0
+
+require (
+	golang.org/x/crypto v0.40.0
+	golang.org/x/sys v0.34.0
+	golang.org/x/term v0.33.0
+	rsc.io/qr v0.2.0
+)
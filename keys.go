@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+	"golang.org/x/term"
+)
+
+// Key read in cbreak mode when Ctrl-C is pressed
+const keyInterrupt = 3
+
+// Start delivering single keypresses from the terminal on stdin. The
+// returned function restores the terminal; when stdin isn't a terminal
+// (or the platform isn't supported) the channel never delivers.
+func startKeys() (<-chan byte, func()) {
+	keys := make(chan byte)
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return keys, func() {}
+	}
+
+	state, err := term.GetState(fd)
+	if err != nil {
+		return keys, func() {}
+	}
+	if err := enableCbreak(fd); err != nil {
+		return keys, func() {}
+	}
+
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(buf); err != nil || n == 0 {
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+
+	return keys, func() { term.Restore(fd, state) }
+}
+
+// The entry at a 1-based position in display order
+func displayedEntry(entries []gmfa.TOTPEntry, position int) (gmfa.TOTPEntry, bool) {
+	favorites, others := splitFavorites(entries)
+	shown := append(favorites, others...)
+	if position < 1 || position > len(shown) {
+		return gmfa.TOTPEntry{}, false
+	}
+	return shown[position-1], true
+}
+
+// Copy the code of the entry at a 1-based position in display order
+func copyDisplayed(entries []gmfa.TOTPEntry, position int) {
+	entry, ok := displayedEntry(entries, position)
+	if !ok {
+		return
+	}
+
+	code, err := gmfa.GenerateTOTP(entry, now())
+	if err == nil {
+		err = copyToClipboard(code)
+	}
+	if err != nil {
+		fmt.Printf("\r\033[KError: %s: %v\n", entry.Name, err)
+		return
+	}
+	fmt.Printf("\r\033[KCopied code for %s to the clipboard\n", displayName(entry))
+}
+
+// Unmask the code of the entry at a 1-based position in display order for
+// the next few seconds
+func revealDisplayed(entries []gmfa.TOTPEntry, position int) {
+	if entry, ok := displayedEntry(entries, position); ok {
+		revealName = entry.Name
+		revealUntil = now().Unix() + revealSeconds
+	}
+}
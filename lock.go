@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How long to wait for another gmfa process to release the secrets file
+var lockTimeout = 10 * time.Second
+
+// Interval between attempts to acquire a held lock
+const lockRetryInterval = 100 * time.Millisecond
+
+// Take an exclusive lock on the secrets file for a read-modify-write cycle.
+// The lock is a "<file>.lock" sibling holding the owner's PID; a lock left
+// behind by a process that no longer exists is removed automatically.
+func lockSecrets(filename string) (func(), error) {
+	lockFile := filename + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+
+	// The secrets file's directory may not exist yet on first use
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	for {
+		file, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return func() { os.Remove(lockFile) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file %s: %v", lockFile, err)
+		}
+
+		if pid, stale := staleLock(lockFile); stale {
+			fmt.Fprintf(os.Stderr, "Removing stale lock %s left by process %d\n", lockFile, pid)
+			if err := os.Remove(lockFile); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("failed to remove stale lock %s: %v", lockFile, err)
+			}
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %v waiting for lock %s (remove it if no other gmfa is running)", lockTimeout, lockFile)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Report whether a lock file belongs to a process that is no longer running
+func staleLock(lockFile string) (int, bool) {
+	data, err := os.ReadFile(lockFile)
+	if err != nil {
+		return 0, false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		// Possibly still being written by its owner; let the timeout decide
+		return 0, false
+	}
+
+	return pid, !processAlive(pid)
+}
+
+// Report whether a process with the given PID exists
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// FindProcess only succeeds on Windows if the process exists
+		return true
+	}
+
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The PID of a process that has already exited
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("running a short-lived process: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestLockSecretsRemovesStaleLock(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "gmfa.conf")
+	lockFile := filename + ".lock"
+	if err := os.WriteFile(lockFile, []byte(strconv.Itoa(deadPID(t))+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := lockSecrets(filename)
+	if err != nil {
+		t.Fatalf("lockSecrets with a stale lock: %v", err)
+	}
+	data, err := os.ReadFile(lockFile)
+	if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file holds %q (%v), want our PID %d", data, err, os.Getpid())
+	}
+
+	unlock()
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Errorf("lock file still exists after unlocking: %v", err)
+	}
+}
+
+func TestLockSecretsTimesOutOnLiveLock(t *testing.T) {
+	defer func(timeout time.Duration) { lockTimeout = timeout }(lockTimeout)
+	lockTimeout = 300 * time.Millisecond
+
+	filename := filepath.Join(t.TempDir(), "gmfa.conf")
+	unlock, err := lockSecrets(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	if _, err := lockSecrets(filename); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("second lockSecrets error = %v, want a timeout", err)
+	}
+}
@@ -2,287 +2,2290 @@ package main
 
 import (
 	"bufio"
-	"encoding/base32"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/binary"
-)
+	"github.com/nealhardesty/gmfa/gmfa"
+	"golang.org/x/term"
+)
+
+const (
+	configFile    = ".gmfa.conf" // Default filename in home directory
+	xdgConfigFile = "gmfa.conf"  // Filename under $XDG_CONFIG_HOME/gmfa
+
+	// ANSI escape code for bold text
+	consoleBold = "\033[1m"
+	// ANSI escape code to reset all formatting
+	consoleReset = "\033[0m"
+	// ANSI escape codes to move the cursor home and clear the screen
+	consoleClear = "\033[H\033[2J"
+	// ANSI escape code for struck-through text
+	consoleStrike = "\033[9m"
+	// ANSI escape code for dim text
+	consoleDim = "\033[2m"
+	// ANSI escape codes for code colors by remaining validity
+	consoleGreen  = "\033[32m"
+	consoleYellow = "\033[33m"
+	consoleRed    = "\033[31m"
+	// ANSI escape codes to turn reverse video for the whole screen on and off
+	consoleFlashOn  = "\033[?5h"
+	consoleFlashOff = "\033[?5l"
+	// Terminal bell
+	consoleBell = "\a"
+
+	// Longest wait, in seconds, before retrying a failed redraw
+	maxRetryDelay = 60
+
+	// Number of ".bak" copies of the secrets file kept
+	maxBackups = 10
+
+	// How long -flash keeps the screen inverted
+	flashDuration = 150 * time.Millisecond
+
+	// Width of the countdown bar in characters
+	countdownWidth = 20
+
+	// Codes turn yellow, then red, with this many seconds left of a 30
+	// second period; other periods scale proportionally
+	warnSeconds     = 8
+	criticalSeconds = 3
+
+	// How long a masked code stays revealed after selecting it
+	revealSeconds = 10
+
+	// With -preview, the next code is shown once this few seconds remain
+	previewSeconds = 5
+
+	// Shown in place of a code whose secret can't be used
+	invalidCode = "<invalid secret>"
+)
+
+// Time source for code generation; tests can replace it with a fixed clock
+var now = time.Now
+
+// Whether ANSI formatting is written to the console
+var colorEnabled = true
+
+// Whether displayCodes shows the upcoming code of entries about to rotate
+var previewNext bool
+
+// With -mask, codes are hidden except for the entry named revealName until
+// revealUntil (Unix time)
+var (
+	maskCodes   bool
+	revealName  string
+	revealUntil int64
+)
+
+// Whether displayCodes shows every entry's expiry time and seconds remaining
+var showExpiry bool
+
+// Whether displayCodes numbers the first nine entries, for the 1-9 keys
+var numberEntries bool
+
+// With -dry-run, saveSecrets prints the file it would write and nothing
+// is written
+var dryRun bool
+
+// Whether displayCodes shows entries under a heading for each issuer
+var groupByIssuer bool
+
+// Whether displayCodes leaves out the rules and blank lines around the codes
+var compactLayout bool
+
+// Columns shown by displayCodes; empty means the classic list layout
+var displayColumns []string
+
+// Columns that can be selected with -columns
+var knownColumns = []string{"name", "issuer", "account", "tags", "code", "expires"}
+
+func main() {
+	var err error
+
+	backupCodeName := flag.String("backup-code", "", "show backup codes for `NAME` and consume the next unused one")
+	debug := flag.Bool("debug", false, "enable developer diagnostics")
+	counterBytesName := flag.String("counter-bytes", "", "print the HMAC counter bytes for `NAME` as hex (requires -debug)")
+	favName := flag.String("fav", "", "mark entry `NAME` as a favorite")
+	unfavName := flag.String("unfav", "", "remove entry `NAME` from favorites")
+	once := flag.Bool("once", false, "print the codes once and exit")
+	flag.BoolVar(once, "1", false, "shorthand for -once")
+	plain := flag.Bool("plain", false, "do not clear the screen or print the banner")
+	noColor := flag.Bool("no-color", false, "disable ANSI formatting")
+	mergeFile := flag.String("merge", "", "merge the entries from `FILE` into the secrets file")
+	onConflict := flag.String("on-conflict", "", "how add, import and -merge resolve a name taken by a different secret: keep, replace or both (add and import ask by default, -merge keeps)")
+	flag.BoolVar(&dryRun, "dry-run", false, "print what would be written instead of changing the secrets file")
+	exportURLs := flag.Bool("export-urls", false, "print every entry as an otpauth URL (plaintext secrets!)")
+	confirmPlaintext := flag.Bool("confirm-plaintext", false, "confirm that export and -export-urls may print secrets in plaintext")
+	flag.BoolVar(confirmPlaintext, "include-secrets", false, "same as -confirm-plaintext")
+	flag.DurationVar(&lockTimeout, "lock-timeout", lockTimeout, "how long to wait for another gmfa to release the secrets file")
+	same := flag.Bool("same", false, "report whether the otpauth URLs `URL1 URL2` produce the same codes")
+	controlPath := flag.String("control", "", "run headless, executing commands read from the control file or named pipe at `PATH`")
+	columns := flag.String("columns", "", "comma-separated `LIST` of columns to display ("+strings.Join(knownColumns, ",")+")")
+	encrypt := flag.Bool("encrypt", false, "encrypt the secrets file with a passphrase and exit")
+	flag.StringVar(&keyFile, "key-file", "", "key file `PATH` for secrets stored encrypted as secret=enc:... (default $"+keyFileEnv+")")
+	flag.StringVar(&passphraseCommand, "passphrase-command", "", "shell `COMMAND` printing the secrets file passphrase (after $"+passphraseEnv+", before prompting)")
+	jsonOutput := flag.Bool("json", false, "print the codes once as a JSON array and exit")
+	serveAddr := flag.String("serve", "", "serve codes over HTTP on `ADDR` (e.g. :8080) at /code/NAME, with /healthz")
+	serveToken := flag.String("token", "", "bearer `TOKEN` required by -serve's /code endpoint")
+	watchName := flag.String("watch", "", "show only `NAME`'s code, full screen, until a key is pressed")
+	copyName := flag.String("copy", "", "copy the current code for `NAME` to the clipboard and exit")
+	replace := flag.Bool("replace", false, "same as -on-conflict replace")
+	flag.BoolVar(replace, "overwrite", false, "same as -on-conflict replace")
+	skip := flag.Bool("skip", false, "same as -on-conflict keep")
+	keepBoth := flag.Bool("keep-both", false, "same as -on-conflict both")
+	flag.BoolVar(keepBoth, "force", false, "same as -keep-both")
+	yes := flag.Bool("yes", false, "do not ask for confirmation")
+	configPath := flag.String("config", "", "use the secrets file at `PATH` instead of the default")
+	flag.StringVar(configPath, "c", "", "shorthand for -config")
+	checkClock := flag.Bool("check-drift", false, "compare the local clock with the Date header of an HTTPS server and warn about skew")
+	driftURL := flag.String("drift-url", "https://www.google.com", "`URL` whose Date header -check-drift uses")
+	useNTP := flag.Bool("ntp", false, "correct the local clock with the time from an NTP server")
+	ntpServer := flag.String("ntp-server", "pool.ntp.org", "`HOST` queried by -ntp")
+	offset := flag.Int("offset", 0, "generate codes for `SECONDS` after now (negative for before) to diagnose clock drift")
+	flag.BoolVar(&previewNext, "preview", false, fmt.Sprintf("also show the next code once %d seconds or fewer remain", previewSeconds))
+	secretBytes := flag.Int("secret-bytes", 20, fmt.Sprintf("length in bytes of the secret generated by new (at least %d)", minSecretBytes))
+	flag.IntVar(secretBytes, "gen-bytes", 20, "same as -secret-bytes")
+	unpadded := flag.Bool("gen-unpadded", false, "leave the \"=\" padding off the base32 secret generated by new")
+	save := flag.Bool("save", false, "save the entry generated by new to the secrets file")
+	fixPerms := flag.Bool("fix-perms", false, "restrict a secrets file readable by others to mode 0600")
+	sortEntries := flag.Bool("sort", false, "display entries sorted by issuer and account instead of file order")
+	flag.BoolVar(&maskCodes, "mask", false, fmt.Sprintf("hide codes; pressing an entry's number reveals it for %d seconds", revealSeconds))
+	migration := flag.Bool("migration", false, "export as a single Google Authenticator otpauth-migration URL")
+	tag := flag.String("tag", "", "show only entries tagged `TAG` (set with a tags=a,b parameter in the otpauth URL)")
+	flag.BoolVar(&showExpiry, "show-expiry", false, "show each code's expiry time and seconds remaining")
+	flag.BoolVar(&groupByIssuer, "group", false, "show entries grouped under a heading for each issuer")
+	flag.BoolVar(&compactLayout, "compact", false, "leave out the rules and blank lines around the codes")
+	bell := flag.Bool("bell", false, "ring the terminal bell when the codes rotate")
+	bellBefore := flag.Int("bell-before", 0, "ring the terminal bell `SECONDS` before the codes rotate instead of when they do")
+	flash := flag.Bool("flash", false, "briefly invert the screen when the codes rotate")
+	alignStart := flag.Bool("align-start", false, "wait for the next code rotation before the first display, so it shows a full validity window")
+	refresh := flag.Int("refresh", 0, "redraw the codes every `SECONDS` instead of at each code rotation")
+	secretValue := flag.String("secret", "", "print the current code for the base32 (or hex) `SECRET`, or \"-\" to read it from stdin, without touching the secrets file")
+	secretName := flag.String("name", "", "label for the -secret code")
+	statusFormat := flag.String("format", defaultStatusFormat, "`TEMPLATE` of the status line, with {code}, {remaining} and {name}")
+	window := flag.Int("window", 1, "number of time steps either side of now accepted by verify")
+	showVersion := flag.Bool("version", false, "print the version and build information and exit")
+	args := parseArgs(flag.CommandLine, os.Args[1:])
+
+	if *showVersion {
+		printVersion()
+		return
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "refresh" && *refresh <= 0 {
+			fmt.Println("Error: -refresh must be a positive number of seconds")
+			os.Exit(1)
+		}
+		if f.Name == "bell-before" && *bellBefore <= 0 {
+			fmt.Println("Error: -bell-before must be a positive number of seconds")
+			os.Exit(1)
+		}
+	})
+
+	if *columns != "" {
+		displayColumns, err = parseColumns(*columns)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// When stdout is not a terminal (CI logs, pipes), default to a single
+	// plain print unless the user explicitly chose otherwise
+	if !isTerminal(os.Stdout) {
+		explicit := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+		if !explicit["once"] && !explicit["1"] {
+			*once = true
+		}
+		if !explicit["plain"] {
+			*plain = true
+		}
+		if !explicit["no-color"] {
+			*noColor = true
+		}
+	}
+	colorEnabled = !*noColor
+
+	if *checkClock && (len(args) == 0 || args[0] != "doctor") {
+		checkDrift(*driftURL)
+	}
+
+	shift := time.Duration(*offset) * time.Second
+	if *useNTP {
+		drift, err := ntpOffset(*ntpServer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: NTP query to %s failed, using the local clock: %v\n", *ntpServer, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Local clock is off by %+.3fs according to %s\n", drift.Seconds(), *ntpServer)
+			shift += drift
+		}
+	}
+	if shift != 0 {
+		now = func() time.Time { return time.Now().Add(shift) }
+	}
+
+	if *same {
+		if len(args) != 2 {
+			fmt.Println("Usage: gmfa -same URL1 URL2")
+			os.Exit(2)
+		}
+		equivalent, err := compareOTPAuthURLs(args[0], args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(2)
+		}
+		if !equivalent {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *secretValue != "" {
+		if err := printSecretCode(*secretValue, *secretName); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// How add, import, new -save and -merge resolve a name taken by a
+	// different secret; -replace (-overwrite), -keep-both (-force) and -skip
+	// are older spellings
+	collision := *onConflict
+	aliases := []struct {
+		set    bool
+		policy string
+	}{{*replace, collisionReplace}, {*keepBoth, collisionBoth}, {*skip, collisionKeep}}
+	for _, alias := range aliases {
+		if !alias.set {
+			continue
+		}
+		if collision != collisionAsk && collision != alias.policy {
+			fmt.Println("Error: only one of -on-conflict, -replace, -keep-both and -skip can be given")
+			os.Exit(2)
+		}
+		collision = alias.policy
+	}
+	if err := validCollisionPolicy(collision); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	// Get the path to the config file in home directory, unless given one
+	secretFile := *configPath
+	if secretFile == "" {
+		secretFile, err = getConfigFilePath()
+		if err != nil {
+			fmt.Printf("Error determining config file path: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "verify":
+			if len(args) != 3 {
+				fmt.Println("Usage: gmfa verify NAME CODE [-window N]")
+				os.Exit(2)
+			}
+			ok, err := verifyCode(secretFile, args[1], args[2], *window)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(2)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+			return
+
+		case "import":
+			if len(args) < 2 && isTerminal(os.Stdin) {
+				fmt.Println("Usage: gmfa import URL...  (or pipe URLs on stdin, one per line)")
+				os.Exit(2)
+			}
+			if len(args) < 2 {
+				parsed, err := readImportLines(os.Stdin)
+				if err == nil {
+					err = importEntries(secretFile, parsed, collision)
+				}
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				return
+			}
+			if err := importURLs(secretFile, args[1:], collision); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+
+		case "add":
+			if len(args) != 2 {
+				fmt.Println("Usage: gmfa add [-on-conflict keep|replace|both] OTPAUTH-URL")
+				os.Exit(2)
+			}
+			if err := addEntry(secretFile, args[1], collision); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+
+		case "new":
+			if len(args) != 2 {
+				fmt.Println("Usage: gmfa new LABEL [-secret-bytes N] [-gen-unpadded] [-save]")
+				os.Exit(2)
+			}
+			if err := newEntry(secretFile, args[1], *secretBytes, *unpadded, *save, collision); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+
+		case "qr":
+			if len(args) != 2 {
+				fmt.Println("Usage: gmfa qr NAME")
+				os.Exit(2)
+			}
+			if err := printQR(secretFile, args[1]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+
+		case "rename":
+			if len(args) != 3 {
+				fmt.Println("Usage: gmfa rename OLD-NAME NEW-NAME")
+				os.Exit(2)
+			}
+			if err := renameEntry(secretFile, args[1], args[2]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+
+		case "export":
+			confirmPlaintextExport(*confirmPlaintext, "-include-secrets")
+			export := exportSecretURLs
+			if *migration {
+				export = exportMigrationURL
+			}
+			if err := export(secretFile); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+
+		case "check":
+			if len(args) > 2 {
+				fmt.Println("Usage: gmfa check [FILE]")
+				os.Exit(2)
+			}
+			file := secretFile
+			if len(args) == 2 {
+				file = args[1]
+			}
+			if err := checkSecrets(file); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+
+		case "doctor":
+			if len(args) != 1 {
+				fmt.Println("Usage: gmfa doctor [-check-drift]")
+				os.Exit(2)
+			}
+			if err := runDoctor(secretFile, *configPath, *checkClock, *driftURL); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+
+		case "status":
+			if len(args) != 2 {
+				fmt.Fprintln(os.Stderr, "Usage: gmfa status NAME [-format TEMPLATE]")
+				os.Exit(2)
+			}
+			if err := printStatus(secretFile, args[1], *statusFormat); err != nil {
+				// Print nothing on stdout, so a status bar shows nothing
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+
+		case "seal":
+			if len(args) != 1 {
+				fmt.Println("Usage: gmfa seal -key-file PATH")
+				os.Exit(2)
+			}
+			if err := sealSecrets(secretFile); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+
+		case "reorder":
+			if err := reorderEntries(secretFile); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+
+		case "list":
+			if err := listEntries(secretFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+
+		case "completion":
+			if len(args) != 2 {
+				fmt.Println("Usage: gmfa completion bash|zsh|fish")
+				os.Exit(2)
+			}
+			if err := printCompletion(args[1]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+
+		case "remove":
+			if len(args) != 2 {
+				fmt.Println("Usage: gmfa remove NAME [-yes]")
+				os.Exit(2)
+			}
+			if err := removeEntry(secretFile, args[1], *yes); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	if *encrypt {
+		if err := encryptSecretsFile(secretFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *serveAddr != "" {
+		if err := runServer(*serveAddr, secretFile, *serveToken); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *watchName != "" {
+		if err := runWatch(secretFile, *watchName); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *copyName != "" {
+		if err := copyCode(secretFile, *copyName); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *counterBytesName != "" {
+		if !*debug {
+			fmt.Println("Error: -counter-bytes is a diagnostic and requires -debug")
+			os.Exit(1)
+		}
+		if err := printCounterBytes(secretFile, *counterBytesName, now().Unix()); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *favName != "" || *unfavName != "" {
+		name, fav := *favName, true
+		if *unfavName != "" {
+			name, fav = *unfavName, false
+		}
+		if err := setFavorite(secretFile, name, fav); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *exportURLs {
+		confirmPlaintextExport(*confirmPlaintext, "-confirm-plaintext")
+		if err := exportSecretURLs(secretFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *mergeFile != "" {
+		if err := mergeSecrets(secretFile, *mergeFile, collision); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *controlPath != "" {
+		if err := runControl(*controlPath, secretFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *backupCodeName != "" {
+		if err := useBackupCode(secretFile, *backupCodeName); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Read MFA secrets from file
+	entries, err := readSecrets(secretFile)
+	if err == nil {
+		checkPermissions(secretFile, *fixPerms)
+	}
+	if (*once || *jsonOutput) && (err != nil || len(entries) == 0) {
+		// Scripts get a failure, not an interactive prompt
+		if err == nil {
+			err = fmt.Errorf("no MFA secrets found")
+		}
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", secretFile, err)
+		os.Exit(1)
+	}
+	if err != nil && (*configPath != "" || !os.IsNotExist(err) && isEncryptedFile(secretFile)) {
+		// Never fall back to the prompt for an explicitly chosen file, or
+		// for an encrypted one, which the prompt would overwrite
+		fmt.Printf("Error: %s: %v\n", secretFile, err)
+		os.Exit(1)
+	}
+	if err != nil || len(entries) == 0 {
+		// File doesn't exist or is empty
+		if err != nil {
+			fmt.Printf("Secrets file not found or couldn't be read: %v\n", err)
+		} else {
+			fmt.Println("No MFA secrets found in the file.")
+		}
+
+		// Without a terminal the prompt would just read EOF
+		if !isTerminal(os.Stdin) {
+			fmt.Fprintf(os.Stderr, "Error: no MFA secrets in %s and stdin is not a terminal to prompt for them.\n", secretFile)
+			fmt.Fprintln(os.Stderr, "Create the file, point -config at one, or add entries with \"gmfa import\".")
+			os.Exit(1)
+		}
+
+		// Ask user to input URL via command line
+		entries = promptForMFAUrl()
+
+		// Save the URLs to the file for future use
+		if len(entries) > 0 {
+			err := saveSecrets(secretFile, entries)
+			if err != nil {
+				fmt.Printf("Warning: Failed to save secrets to %s: %v\n", secretFile, err)
+			}
+		} else {
+			fmt.Println("No valid MFA URLs provided. Exiting.")
+			os.Exit(1)
+		}
+	}
+
+	if *sortEntries {
+		sortByName(entries)
+	}
+	sortByOrder(entries)
+	if groupByIssuer {
+		groupEntries(entries)
+	}
+
+	loaded := entries
+	if *tag != "" {
+		entries = filterByTag(entries, *tag)
+		if len(entries) == 0 {
+			fmt.Fprintf(os.Stderr, "No entry is tagged %q\n", *tag)
+			os.Exit(1)
+		}
+	}
+
+	// A positional argument narrows the display to matching entries
+	if len(args) > 0 {
+		filter := strings.Join(args, " ")
+		entries = filterEntries(entries, filter)
+		if len(entries) == 0 {
+			fmt.Fprintf(os.Stderr, "No entry matches %q. Available entries:\n", filter)
+			for _, entry := range loaded {
+				fmt.Fprintf(os.Stderr, " * %s\n", entry.Name)
+			}
+			os.Exit(1)
+		}
+
+		if len(entries) == 1 && !*jsonOutput {
+			// A single match prints just the code, for scripts
+			code, err := gmfa.GenerateTOTP(entries[0], now())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: %v\n", entries[0].Name, err)
+				os.Exit(1)
+			}
+			fmt.Println(code)
+			if err := advanceHOTPCounters(secretFile, entries); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Failed to save HOTP counter to %s: %v\n", secretFile, err)
+			}
+			return
+		}
+	}
+
+	if *jsonOutput {
+		if err := printJSON(entries); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := advanceHOTPCounters(secretFile, entries); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to save HOTP counters to %s: %v\n", secretFile, err)
+		}
+		return
+	}
+
+	if !*plain && !*once {
+		clearScreen()
+		fmt.Println("2FA TOTP Console Application")
+		printRule(os.Stdout)
+		fmt.Printf("Loaded %d MFA entries from %s\n", len(entries), secretFile)
+		if !compactLayout {
+			fmt.Println()
+		}
+	}
+
+	// Display codes immediately first, or once they next rotate
+	if *alignStart {
+		rotation, _ := nextRotation(entries, now().Unix())
+		if !*plain {
+			fmt.Printf("Waiting for the next code rotation at %s\n", time.Unix(rotation, 0).Format("15:04:05"))
+		}
+		time.Sleep(time.Unix(rotation, 0).Sub(now()))
+	}
+	numberEntries = !*once && isTerminal(os.Stdout) && isTerminal(os.Stdin)
+	if err := redrawCodes(entries, false); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to display the codes: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Shown HOTP codes are used up; persist their advanced counters while
+	// the display keeps showing the codes the user just saw
+	if err := advanceHOTPCounters(secretFile, entries); err != nil {
+		fmt.Printf("Warning: Failed to save HOTP counters to %s: %v\n", secretFile, err)
+	}
+	if *once {
+		return
+	}
+
+	// Main loop to display codes at each rotation, waking for whichever
+	// entry's code rotates next, or every -refresh seconds. On a terminal a
+	// countdown bar under the codes ticks every second in between (only at
+	// redraws with -refresh); codes are only regenerated when the display
+	// is redrawn. Keys: q quits, r refreshes, 1-9 copies that entry's code
+	// (or reveals it, with -mask).
+	countdown := isTerminal(os.Stdout)
+	var keys <-chan byte
+	if countdown {
+		var restore func()
+		keys, restore = startKeys()
+		defer restore()
+	}
+
+	// Leave the terminal as we found it on Ctrl-C or kill
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	// A failed redraw leaves the previous codes on screen and is retried
+	// after a delay doubling with each consecutive failure
+	lastDraw := now().Unix()
+	failures, retryAt := 0, int64(0)
+	warned := int64(0) // The rotation -bell-before last rang for
+	for {
+		currentTime := now().Unix()
+		rotation, period := nextRotation(entries, currentTime)
+		if *bellBefore > 0 && countdown && rotation != warned && rotation-currentTime <= int64(*bellBefore) {
+			fmt.Print(consoleBell)
+			warned = rotation
+		}
+		redraw := rotation
+		if *refresh > 0 {
+			redraw = lastDraw + int64(*refresh)
+		} else if preview := nextPreview(entries, currentTime); preview != 0 {
+			redraw = min(redraw, preview)
+		}
+		if revealUntil > currentTime {
+			redraw = min(redraw, revealUntil) // Re-mask the revealed code
+		}
+		if failures > 0 {
+			redraw = retryAt
+		}
+
+		wait := time.Unix(redraw, 0).Sub(now())
+		if warnAt := rotation - int64(*bellBefore); *bellBefore > 0 && warnAt > currentTime {
+			wait = min(wait, time.Unix(warnAt, 0).Sub(now())) // Wake for -bell-before
+		}
+		if countdown {
+			drawCountdown(rotation-currentTime, period)
+			if *refresh == 0 {
+				wait = time.Unix(currentTime+1, 0).Sub(now())
+			}
+		}
+
+		select {
+		case <-signals:
+			fmt.Println()
+			return
+		case key := <-keys:
+			switch {
+			case key == 'q' || key == keyInterrupt:
+				fmt.Println()
+				return
+			case key >= '1' && key <= '9' && maskCodes:
+				revealDisplayed(entries, int(key-'0'))
+			case key >= '1' && key <= '9':
+				copyDisplayed(entries, int(key-'0'))
+				continue
+			case key != 'r':
+				continue
+			}
+		case <-time.After(wait):
+			if now().Unix() < redraw {
+				continue
+			}
+		}
+		if countdown {
+			fmt.Println()
+		}
+
+		if err := redrawCodes(entries, !*plain); err != nil {
+			failures++
+			delay := min(int64(1)<<min(failures-1, 6), maxRetryDelay)
+			retryAt = now().Unix() + delay
+			fmt.Fprintf(os.Stderr, "%s Error: failed to redraw the codes (attempt %d): %v; retrying in %ds\n", time.Now().Format("15:04:05"), failures, err, delay)
+			continue
+		}
+		failures = 0
+		if now().Unix() >= rotation {
+			notifyRotation(*bell && *bellBefore == 0, *flash)
+		}
+		lastDraw = now().Unix()
+	}
+}
+
+// Render the codes off screen, then show them with a single write (after
+// clearing the screen, if clear is set) so a failed redraw leaves the
+// previous codes in place
+func redrawCodes(entries []gmfa.TOTPEntry, clear bool) error {
+	var frame bytes.Buffer
+	if clear && isTerminal(os.Stdout) {
+		frame.WriteString(consoleClear)
+	}
+	displayCodes(&frame, entries)
+	_, err := os.Stdout.Write(frame.Bytes())
+	return err
+}
+
+// Ring the bell and/or flash the screen for a code rotation; output that
+// isn't going to a terminal is left alone
+func notifyRotation(bell bool, flash bool) {
+	if !isTerminal(os.Stdout) {
+		return
+	}
+	if bell {
+		fmt.Print(consoleBell)
+	}
+	if flash {
+		fmt.Print(consoleFlashOn)
+		time.Sleep(flashDuration)
+		fmt.Print(consoleFlashOff)
+	}
+}
+
+// Clear terminal screen with ANSI escapes; output that isn't going to a
+// terminal is left alone so files and pipes don't fill with escape codes
+func clearScreen() {
+	if !isTerminal(os.Stdout) {
+		return
+	}
+	fmt.Print(consoleClear)
+}
+
+// Parse flags that may appear before, between or after positional
+// arguments (e.g. "gmfa verify github 123456 -window 2") and return the
+// positional arguments in order
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional
+		}
+		if len(args) > len(rest) && args[len(args)-len(rest)-1] == "--" {
+			// Everything after "--" is positional
+			return append(positional, rest...)
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// Report whether f is connected to a terminal rather than a pipe, a file
+// or another device such as /dev/null
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// Wrap text in an ANSI formatting code, unless color output is disabled
+func styled(code string, text string) string {
+	if !colorEnabled {
+		return text
+	}
+	return code + text + consoleReset
+}
+
+// Write the current TOTP codes to w
+func displayCodes(w io.Writer, entries []gmfa.TOTPEntry) {
+	currentTime := now().Unix()
+
+	// When all codes expire together everyone shares one window; otherwise
+	// each line shows its own
+	mixedPeriods := false
+	codeWidth := 0
+	var validUntil int64
+	for _, entry := range entries {
+		codeWidth = max(codeWidth, gmfa.DigitCount(entry))
+		if gmfa.IsHOTP(entry) {
+			continue // Counter-based codes don't expire
+		}
+		if validUntil != 0 && codeValidUntil(entry, currentTime) != validUntil {
+			mixedPeriods = true
+		}
+		validUntil = codeValidUntil(entry, currentTime)
+	}
+
+	if !compactLayout {
+		fmt.Fprintln(w)
+	}
+	if mixedPeriods || validUntil == 0 {
+		fmt.Fprintln(w, "TOTP Codes:")
+	} else {
+		fmt.Fprintf(w, "TOTP Codes (valid until %s):\n", time.Unix(validUntil, 0).Format("15:04:05"))
+	}
+	printRule(w)
+
+	favorites, others := splitFavorites(entries)
+	if len(displayColumns) > 0 {
+		printColumns(w, append(favorites, others...), currentTime)
+		return
+	}
+
+	// Names shorter than the classic 20 columns keep the classic layout
+	nameWidth := 20
+	for _, entry := range entries {
+		nameWidth = max(nameWidth, displayWidth(displayName(entry)+shortSecretMarker(entry)))
+	}
+
+	// Positions count favorites first, as the 1-9 keys do
+	for i, entry := range favorites {
+		printCode(w, entry, i+1, currentTime, mixedPeriods || showExpiry, nameWidth, codeWidth)
+	}
+	if len(favorites) > 0 && len(others) > 0 {
+		printRule(w)
+	}
+	for i, entry := range others {
+		if groupByIssuer && (i == 0 || others[i-1].Issuer != entry.Issuer) {
+			printGroupHeading(w, entry.Issuer, i == 0)
+		}
+		printCode(w, entry, len(favorites)+i+1, currentTime, mixedPeriods || showExpiry, nameWidth, codeWidth)
+	}
+}
+
+// Print the heading of an issuer's group; entries without an issuer are
+// grouped under "Other"
+func printGroupHeading(w io.Writer, issuer string, first bool) {
+	if issuer == "" {
+		issuer = "Other"
+	}
+	if !first && !compactLayout {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, styled(consoleBold, "== "+issuer+" =="))
+}
+
+// Print the horizontal rule separating sections, unless -compact
+func printRule(w io.Writer) {
+	if !compactLayout {
+		fmt.Fprintln(w, "-----------------------------")
+	}
+}
+
+// Print a single entry's code line with its name padded to nameWidth,
+// optionally with its own validity window aligned after codes padded to
+// codeWidth. position is the entry's 1-based place in the display, shown
+// for the keys that act on it.
+func printCode(w io.Writer, entry gmfa.TOTPEntry, position int, currentTime int64, showValidity bool, nameWidth int, codeWidth int) {
+	code, err := gmfa.GenerateTOTP(entry, time.Unix(currentTime, 0))
+	shown := styled(consoleBold+codeColor(entry, currentTime), maskCode(entry, code, currentTime))
+	if err != nil {
+		code, shown = invalidCode, invalidCode
+	}
+
+	name := padRight(displayName(entry)+shortSecretMarker(entry), nameWidth)
+	bullet := " * "
+	if numberEntries {
+		bullet = "    "
+		if position <= 9 {
+			bullet = fmt.Sprintf("[%d] ", position)
+		}
+	}
+	line := fmt.Sprintf("%s%s: %s", bullet, name, shown)
+	if gmfa.IsHOTP(entry) {
+		line += strings.Repeat(" ", max(0, codeWidth-len(code)))
+		line += fmt.Sprintf(" (counter %d)", entry.Counter)
+	} else if showValidity {
+		validUntil := codeValidUntil(entry, currentTime)
+		line += strings.Repeat(" ", max(0, codeWidth-len(code)))
+		if showExpiry {
+			line += fmt.Sprintf(" (valid until %s, %2ds left)", time.Unix(validUntil, 0).Format("15:04:05"), validUntil-currentTime)
+		} else {
+			line += fmt.Sprintf(" (valid until %s)", time.Unix(validUntil, 0).Format("15:04:05"))
+		}
+	}
+	if previewNext && !gmfa.IsHOTP(entry) && err == nil && codeValidUntil(entry, currentTime)-currentTime <= previewSeconds {
+		if next, err := gmfa.GenerateTOTP(entry, time.Unix(currentTime+gmfa.EntryPeriod(entry), 0)); err == nil {
+			line += " next: " + styled(consoleDim, maskCode(entry, next, currentTime))
+		}
+	}
+	fmt.Fprintln(w, line)
+}
+
+// Marker appended to the names of entries whose secret looks truncated
+func shortSecretMarker(entry gmfa.TOTPEntry) string {
+	if gmfa.ShortSecret(entry) {
+		return " ⚠"
+	}
+	return ""
+}
+
+// Color for an entry's code: green with plenty of time left, then yellow,
+// then red just before it rotates
+func codeColor(entry gmfa.TOTPEntry, currentTime int64) string {
+	if gmfa.IsHOTP(entry) {
+		return consoleGreen
+	}
+
+	period := gmfa.EntryPeriod(entry)
+	remaining := codeValidUntil(entry, currentTime) - currentTime
+	switch {
+	case remaining*gmfa.DefaultPeriod <= criticalSeconds*period:
+		return consoleRed
+	case remaining*gmfa.DefaultPeriod <= warnSeconds*period:
+		return consoleYellow
+	default:
+		return consoleGreen
+	}
+}
+
+// The code to display for an entry: bullets in its place under -mask,
+// unless the entry is currently revealed
+func maskCode(entry gmfa.TOTPEntry, code string, currentTime int64) string {
+	if !maskCodes || (entry.Name == revealName && currentTime < revealUntil) {
+		return code
+	}
+	return strings.Repeat("•", len(code))
+}
+
+// Unix time at which the entry's current code expires
+func codeValidUntil(entry gmfa.TOTPEntry, currentTime int64) int64 {
+	return gmfa.ValidUntil(entry, currentTime)
+}
+
+// Unix time of the soonest code rotation across all time-based entries,
+// and the period of the entry rotating then
+func nextRotation(entries []gmfa.TOTPEntry, currentTime int64) (int64, int64) {
+	next, period := int64(0), int64(gmfa.DefaultPeriod)
+	for _, entry := range entries {
+		if gmfa.IsHOTP(entry) {
+			continue
+		}
+		if validUntil := codeValidUntil(entry, currentTime); next == 0 || validUntil < next {
+			next, period = validUntil, gmfa.EntryPeriod(entry)
+		}
+	}
+	if next == 0 {
+		// Only HOTP entries; just redraw at the default step
+		next = currentTime + gmfa.DefaultPeriod
+	}
+	return next, period
+}
+
+// Unix time after currentTime at which -preview next has a code to add,
+// or 0 if previews are off or nothing is due before its rotation
+func nextPreview(entries []gmfa.TOTPEntry, currentTime int64) int64 {
+	if !previewNext {
+		return 0
+	}
+
+	next := int64(0)
+	for _, entry := range entries {
+		if gmfa.IsHOTP(entry) {
+			continue
+		}
+		if preview := codeValidUntil(entry, currentTime) - previewSeconds; preview > currentTime && (next == 0 || preview < next) {
+			next = preview
+		}
+	}
+	return next
+}
+
+// Redraw the countdown line, e.g. "[######--------------] 6s"
+func drawCountdown(remaining int64, period int64) {
+	filled := int(remaining * countdownWidth / period)
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", countdownWidth-filled)
+	fmt.Printf("\r\033[K[%s] %ds", bar, remaining)
+}
+
+// A code as printed by -json
+type jsonCode struct {
+	Name             string   `json:"name"`
+	Issuer           string   `json:"issuer,omitempty"`
+	Account          string   `json:"account,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+	Code             string   `json:"code,omitempty"`
+	ValidUntil       int64    `json:"valid_until,omitempty"`
+	SecondsRemaining int64    `json:"seconds_remaining,omitempty"`
+	Counter          *uint64  `json:"counter,omitempty"`
+	Error            string   `json:"error,omitempty"`
+}
+
+// An entry's code at currentTime in its JSON form
+func newJSONCode(entry gmfa.TOTPEntry, currentTime int64) jsonCode {
+	item := jsonCode{Name: entry.Name, Issuer: entry.Issuer, Account: entry.Account, Tags: entry.Tags}
+
+	code, err := gmfa.GenerateTOTP(entry, time.Unix(currentTime, 0))
+	if err != nil {
+		item.Error = err.Error()
+	} else {
+		item.Code = code
+	}
+	if gmfa.IsHOTP(entry) {
+		counter := entry.Counter
+		item.Counter = &counter
+	} else {
+		item.ValidUntil = codeValidUntil(entry, currentTime)
+		item.SecondsRemaining = item.ValidUntil - currentTime
+	}
+	return item
+}
+
+// Print the current codes as a JSON array
+func printJSON(entries []gmfa.TOTPEntry) error {
+	currentTime := now().Unix()
+
+	codes := make([]jsonCode, 0, len(entries))
+	for _, entry := range entries {
+		codes = append(codes, newJSONCode(entry, currentTime))
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(codes)
+}
+
+// Print entries as an aligned table of the selected columns. Cells are
+// padded by display width rather than bytes so wide characters line up.
+func printColumns(w io.Writer, entries []gmfa.TOTPEntry, currentTime int64) {
+	header := make([]string, len(displayColumns))
+	for i, column := range displayColumns {
+		header[i] = strings.ToUpper(column)
+	}
+	rows := [][]string{header}
+
+	for _, entry := range entries {
+		cells := make([]string, len(displayColumns))
+		for i, column := range displayColumns {
+			switch column {
+			case "name":
+				cells[i] = entry.Name + shortSecretMarker(entry)
+			case "issuer":
+				cells[i] = entry.Issuer
+			case "account":
+				cells[i] = entry.Account
+			case "tags":
+				cells[i] = strings.Join(entry.Tags, ",")
+			case "code":
+				code, err := gmfa.GenerateTOTP(entry, time.Unix(currentTime, 0))
+				if err != nil {
+					cells[i] = invalidCode
+					continue
+				}
+				cells[i] = styled(consoleBold+codeColor(entry, currentTime), maskCode(entry, code, currentTime))
+			case "expires":
+				if gmfa.IsHOTP(entry) {
+					cells[i] = fmt.Sprintf("counter %d", entry.Counter)
+					continue
+				}
+				validUntil := codeValidUntil(entry, currentTime)
+				cells[i] = fmt.Sprintf("%s (%ds)", time.Unix(validUntil, 0).Format("15:04:05"), validUntil-currentTime)
+			}
+		}
+		rows = append(rows, cells)
+	}
+
+	widths := make([]int, len(displayColumns))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], displayWidth(cell))
+		}
+	}
+	for _, row := range rows {
+		line := ""
+		for i, cell := range row {
+			if i < len(row)-1 {
+				cell = padRight(cell, widths[i]+2)
+			}
+			line += cell
+		}
+		fmt.Fprintln(w, " "+line)
+	}
+}
+
+// Parse and validate a comma-separated -columns list
+func parseColumns(list string) ([]string, error) {
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		column = strings.ToLower(strings.TrimSpace(column))
+		known := false
+		for _, k := range knownColumns {
+			if column == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column %q (known columns: %s)", column, strings.Join(knownColumns, ", "))
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// Human-friendly entry name, e.g. "GitHub (alice@example.com)"
+func displayName(entry gmfa.TOTPEntry) string {
+	switch {
+	case entry.Issuer == "":
+		return entry.Name
+	case entry.Account == "":
+		return entry.Issuer
+	default:
+		return fmt.Sprintf("%s (%s)", entry.Issuer, entry.Account)
+	}
+}
+
+// Sort entries by issuer, then account, ignoring case; entries without an
+// issuer sort by their whole label
+func sortByName(entries []gmfa.TOTPEntry) {
+	key := func(entry gmfa.TOTPEntry) (string, string) {
+		if entry.Issuer == "" {
+			return strings.ToLower(entry.Name), ""
+		}
+		return strings.ToLower(entry.Issuer), strings.ToLower(entry.Account)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		issuerI, accountI := key(entries[i])
+		issuerJ, accountJ := key(entries[j])
+		if issuerI != issuerJ {
+			return issuerI < issuerJ
+		}
+		return accountI < accountJ
+	})
+}
+
+// Move entries with an explicit display order to the front, by that order,
+// keeping the rest in their current order
+func sortByOrder(entries []gmfa.TOTPEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].Order, entries[j].Order
+		return a != 0 && (b == 0 || a < b)
+	})
+}
+
+// Gather entries with the same issuer together, groups in the order their
+// issuers first appear and entries without an issuer last, preserving the
+// order within each group
+func groupEntries(entries []gmfa.TOTPEntry) {
+	rank := make(map[string]int)
+	for _, entry := range entries {
+		if _, ok := rank[entry.Issuer]; !ok && entry.Issuer != "" {
+			rank[entry.Issuer] = len(rank)
+		}
+	}
+	rank[""] = len(rank)
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return rank[entries[i].Issuer] < rank[entries[j].Issuer]
+	})
+}
+
+// Split entries into favorites and the rest, preserving order within each
+func splitFavorites(entries []gmfa.TOTPEntry) (favorites, others []gmfa.TOTPEntry) {
+	for _, entry := range entries {
+		if entry.Fav {
+			favorites = append(favorites, entry)
+		} else {
+			others = append(others, entry)
+		}
+	}
+	return favorites, others
+}
+
+// Mark or unmark an entry as a favorite and persist the change
+func setFavorite(filename string, name string, fav bool) error {
+	unlock, err := lockSecrets(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+
+	i, err := findEntry(entries, name)
+	if err != nil {
+		return err
+	}
+
+	entries[i].Fav = fav
+	return saveSecrets(filename, entries)
+}
+
+// Get the full path to the config file in the user's home directory
+func getConfigFilePath() (string, error) {
+	if path := os.Getenv("GMFA_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine home directory: %v", err)
+	}
+	legacyPath := filepath.Join(homeDir, configFile)
+
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		xdgPath := filepath.Join(configHome, "gmfa", xdgConfigFile)
+		// Keep existing users on their legacy file until they move it
+		if _, err := os.Stat(xdgPath); os.IsNotExist(err) {
+			if _, err := os.Stat(legacyPath); err == nil {
+				return legacyPath, nil
+			}
+		}
+		return xdgPath, nil
+	}
+
+	return legacyPath, nil
+}
+
+// Prompt user to enter MFA URLs via command line
+func promptForMFAUrl() []gmfa.TOTPEntry {
+	var entries []gmfa.TOTPEntry
+	scanner := bufio.NewScanner(os.Stdin)
+
+	fmt.Println("Please enter your MFA URL(s).")
+	fmt.Println("Format: otpauth://totp/Service:user@example.com?secret=ABCDEFGHIJKLMNOP&issuer=Service")
+	fmt.Println("Google Authenticator exports (otpauth-migration://...) are accepted too.")
+	fmt.Println("Enter an empty line when finished.")
+
+	for {
+		fmt.Print("Enter MFA URL: ")
+		scanner.Scan()
+		input := strings.TrimSpace(scanner.Text())
+
+		if input == "" {
+			break // Empty line signals end of input
+		}
+
+		// Parse and validate the URL
+		parsed, err := parseImportURL(input)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+
+		for _, entry := range parsed {
+			entries = append(entries, entry)
+			fmt.Printf("Added: %s\n", entry.Name)
+		}
+	}
+
+	return entries
+}
+
+// Parse a pasted URL: either a single otpauth URL or a Google Authenticator
+// otpauth-migration export holding many accounts
+func parseImportURL(input string) ([]gmfa.TOTPEntry, error) {
+	if strings.HasPrefix(input, gmfa.MigrationScheme+":") {
+		entries, skipped, err := gmfa.ParseMigrationURL(input)
+		for _, err := range skipped {
+			fmt.Printf("Warning: Skipping account in migration data: %v\n", err)
+		}
+		return entries, err
+	}
+
+	entry, err := gmfa.ParseOTPAuthURL(input)
+	if err != nil {
+		return nil, err
+	}
+	return []gmfa.TOTPEntry{entry}, nil
+}
+
+// Append the entries from each URL to the secrets file
+func importURLs(filename string, urls []string, policy string) error {
+	var parsed []gmfa.TOTPEntry
+	for _, input := range urls {
+		entries, err := parseImportURL(input)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, entries...)
+	}
+	return importEntries(filename, parsed, policy)
+}
+
+// Parse one URL per line from r, as piped to "gmfa import", in the secrets
+// file format, so the comments of an export are kept. Migration URLs are
+// accepted too; @include lines and invalid lines are reported and skipped.
+func readImportLines(r io.Reader) ([]gmfa.TOTPEntry, error) {
+	parsed, includes, invalid, err := gmfa.ReadSecrets(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, include := range includes {
+		fmt.Printf("Warning: Skipping @include %s; import that file itself\n", include)
+	}
+
+	for _, line := range invalid {
+		if strings.HasPrefix(line.Text, gmfa.MigrationScheme+":") {
+			entries, err := parseImportURL(line.Text)
+			if err == nil {
+				parsed = append(parsed, entries...)
+				continue
+			}
+			line.Err = err
+		}
+		fmt.Printf("Warning: Skipping invalid MFA URL on line %d: %v\n", line.Line, line.Err)
+	}
+	return parsed, nil
+}
+
+// Append entries to the secrets file, skipping any whose name is already
+// taken
+func importEntries(filename string, parsed []gmfa.TOTPEntry, policy string) error {
+	unlock, err := lockSecrets(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := readSecrets(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	changed := 0
+	for _, entry := range parsed {
+		if hasEntry(entries, entry) {
+			fmt.Printf("Already present: %s\n", entry.Name)
+			continue
+		}
+		if existing := entryNamed(entries, entry.Name); existing != nil {
+			action, err := resolveNameCollision(entry, policy)
+			if err != nil {
+				return err
+			}
+			switch action {
+			case collisionReplace:
+				replaceSecret(existing, entry)
+				changed++
+				fmt.Printf("Replaced: %s\n", entry.Name)
+				continue
+			case collisionKeep:
+				fmt.Printf("Skipping %s\n", entry.Name)
+				continue
+			}
+		}
+		entries = append(entries, entry)
+		changed++
+		fmt.Printf("Added: %s\n", entry.Name)
+	}
+
+	if changed == 0 {
+		return nil
+	}
+	return saveSecrets(filename, entries)
+}
+
+// Return the entry with exactly this name, or nil
+func entryNamed(entries []gmfa.TOTPEntry, name string) *gmfa.TOTPEntry {
+	for i := range entries {
+		if entries[i].Name == name {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+// Drop entries repeating the name and secret of an earlier one, returning
+// the rest in order and how many were dropped
+func dedupeEntries(entries []gmfa.TOTPEntry) ([]gmfa.TOTPEntry, int) {
+	var unique []gmfa.TOTPEntry
+	for _, entry := range entries {
+		if !hasEntry(unique, entry) {
+			unique = append(unique, entry)
+		}
+	}
+	return unique, len(entries) - len(unique)
+}
+
+// Report whether entries already holds an entry with the same name and
+// secret; secrets that couldn't be decrypted must have the same ciphertext
+func hasEntry(entries []gmfa.TOTPEntry, entry gmfa.TOTPEntry) bool {
+	for _, existing := range entries {
+		if existing.Name == entry.Name && existing.Secret == entry.Secret && (entry.Secret != "" || existing.Sealed == entry.Sealed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Names shared by entries with different secrets
+func nameConflicts(entries []gmfa.TOTPEntry) []string {
+	var conflicts []string
+	seen := make(map[string]bool)
+	for i, entry := range entries {
+		if seen[entry.Name] {
+			continue
+		}
+		for _, other := range entries[i+1:] {
+			if other.Name == entry.Name {
+				conflicts = append(conflicts, entry.Name)
+				seen[entry.Name] = true
+				break
+			}
+		}
+	}
+	return conflicts
+}
+
+// Names of entries that always produce the same codes as each other, in
+// groups of two or more; usually a secret pasted into the wrong entry
+func secretCollisions(entries []gmfa.TOTPEntry) [][]string {
+	var keys []string
+	groups := make(map[string][]string)
+	for _, entry := range entries {
+		key, ok := codeStreamKey(entry)
+		if !ok {
+			continue
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], entry.Name)
+	}
+
+	var collisions [][]string
+	for _, key := range keys {
+		if len(groups[key]) > 1 {
+			collisions = append(collisions, groups[key])
+		}
+	}
+	return collisions
+}
+
+// A key equal for entries that generate the same codes: the same decoded
+// secret, type, algorithm, digits, period and epoch. Reports false if the
+// secret can't be decoded.
+func codeStreamKey(entry gmfa.TOTPEntry) (string, bool) {
+	secret, err := gmfa.DecodeSecret(entry)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%x/%s/%s/%d/%d/%d", secret, entry.Type, gmfa.AlgorithmName(entry), gmfa.DigitCount(entry), gmfa.EntryPeriod(entry), entry.Epoch), true
+}
+
+// Warn when the secrets file is accessible to anyone but its owner, and
+// tighten it to 0600 if fix is set. Windows has no Unix modes to check.
+func checkPermissions(filename string, fix bool) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil || info.Mode().Perm()&^0600 == 0 {
+		return
+	}
+
+	if fix {
+		if err := os.Chmod(filename, 0600); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to fix permissions of %s: %v\n", filename, err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Fixed permissions of %s: %04o -> 0600\n", filename, info.Mode().Perm())
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, styled(consoleBold, fmt.Sprintf("WARNING: %s has mode %04o and may be readable by other users.", filename, info.Mode().Perm())))
+	fmt.Fprintln(os.Stderr, "Run with -fix-perms to restrict it to 0600.")
+}
+
+// Entries whose name contains the filter, case-insensitively
+func filterEntries(entries []gmfa.TOTPEntry, filter string) []gmfa.TOTPEntry {
+	needle := strings.ToLower(filter)
+	var matches []gmfa.TOTPEntry
+	for _, entry := range entries {
+		if strings.Contains(strings.ToLower(entry.Name), needle) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
+// Return the entries carrying tag
+func filterByTag(entries []gmfa.TOTPEntry, tag string) []gmfa.TOTPEntry {
+	var matches []gmfa.TOTPEntry
+	for _, entry := range entries {
+		if gmfa.HasTag(entry, tag) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
+// Find the entry matching name, preferring an exact (case-insensitive) match
+// over a unique substring match. Several entries with exactly that name
+// (kept with -on-conflict both) are as ambiguous as several partial matches.
+func findEntry(entries []gmfa.TOTPEntry, name string) (int, error) {
+	needle := strings.ToLower(name)
+	var exact, matches []int
+	for i, entry := range entries {
+		entryName := strings.ToLower(entry.Name)
+		if entryName == needle {
+			exact = append(exact, i)
+		}
+		if strings.Contains(entryName, needle) {
+			matches = append(matches, i)
+		}
+	}
+
+	switch len(exact) {
+	case 0:
+	case 1:
+		return exact[0], nil
+	default:
+		var names []string
+		for _, i := range exact {
+			names = append(names, fmt.Sprintf("%s (entry %d)", entries[i].Name, i+1))
+		}
+		return -1, fmt.Errorf("%q names %d entries: %s", name, len(exact), strings.Join(names, ", "))
+	}
+
+	switch len(matches) {
+	case 0:
+		return -1, fmt.Errorf("no entry matches %q", name)
+	case 1:
+		return matches[0], nil
+	default:
+		var names []string
+		for _, i := range matches {
+			names = append(names, entries[i].Name)
+		}
+		return -1, fmt.Errorf("%q matches multiple entries: %s", name, strings.Join(names, ", "))
+	}
+}
+
+// Show an entry's backup codes, consume the next unused one and persist it
+func useBackupCode(filename string, name string) error {
+	unlock, err := lockSecrets(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+
+	i, err := findEntry(entries, name)
+	if err != nil {
+		return err
+	}
+	entry := &entries[i]
+	if len(entry.Backup) == 0 {
+		return fmt.Errorf("no backup codes stored for %s", entry.Name)
+	}
+
+	next := -1
+	fmt.Printf("Backup codes for %s:\n", entry.Name)
+	for j, bc := range entry.Backup {
+		if bc.Used {
+			fmt.Printf(" * %s (used)\n", styled(consoleStrike, bc.Code))
+			continue
+		}
+		if next < 0 {
+			next = j
+		}
+		fmt.Printf(" * %s\n", bc.Code)
+	}
+
+	if next < 0 {
+		return fmt.Errorf("all backup codes for %s have been used", entry.Name)
+	}
+
+	entry.Backup[next].Used = true
+	if err := saveSecrets(filename, entries); err != nil {
+		return fmt.Errorf("failed to record used backup code: %v", err)
+	}
+
+	fmt.Printf("\nUse backup code: %s\n", styled(consoleBold, entry.Backup[next].Code))
+	return nil
+}
+
+// Save MFA secrets to file and report it, keeping a backup of the previous
+// contents. With -dry-run, print the resulting file instead.
+func saveSecrets(filename string, entries []gmfa.TOTPEntry) error {
+	return saveSecretsBackup(filename, entries, true)
+}
+
+// Save MFA secrets to file and report it, keeping a backup of the previous
+// contents only if backup is set
+func saveSecretsBackup(filename string, entries []gmfa.TOTPEntry, backup bool) error {
+	if dryRun {
+		content, err := renderSecrets(filename, entries)
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(content)
+		fmt.Printf("Dry run: would write %d entries to %s\n", len(ownEntries(entries)), filename)
+		return nil
+	}
+
+	if backup {
+		if err := backupSecrets(filename); err != nil {
+			return fmt.Errorf("failed to back up %s: %v", filename, err)
+		}
+	}
+	if err := writeSecrets(filename, entries); err != nil {
+		return err
+	}
+
+	fmt.Printf("Saved %d MFA entries to %s\n", len(ownEntries(entries)), filename)
+	return nil
+}
 
-// TOTP configuration
-const (
-	timeStep   = 30 // seconds
-	codeDigits = 6
-	configFile = ".gmfa.conf" // Default filename in home directory
+// The plaintext secrets file holding entries. Entries from included files
+// stay there; only the @include lines are written back.
+func renderSecrets(filename string, entries []gmfa.TOTPEntry) ([]byte, error) {
+	if err := checkIncludedEntries(filename, entries); err != nil {
+		return nil, err
+	}
+	includes, err := secretsIncludes(filename)
+	if err != nil {
+		return nil, err
+	}
 
-	// ANSI escape code for bold text
-	consoleBold = "\033[1m"
-	// ANSI escape code to reset all formatting
-	consoleReset = "\033[0m"
-)
+	var content bytes.Buffer
+	if err := gmfa.WriteSecrets(&content, ownEntries(entries), includes); err != nil {
+		return nil, err
+	}
+	return content.Bytes(), nil
+}
 
-type TOTPEntry struct {
-	Name   string
-	Secret string
+// The entries written to the secrets file itself: those not from included
+// files, without duplicates
+func ownEntries(entries []gmfa.TOTPEntry) []gmfa.TOTPEntry {
+	var own []gmfa.TOTPEntry
+	for _, entry := range entries {
+		if entry.Include == "" {
+			own = append(own, entry)
+		}
+	}
+	own, _ = dedupeEntries(own)
+	return own
 }
 
-func main() {
-	// Get the path to the config file in home directory
-	secretFile, err := getConfigFilePath()
+// Write MFA secrets to file without reporting, for bookkeeping updates;
+// nothing is written with -dry-run
+func writeSecrets(filename string, entries []gmfa.TOTPEntry) error {
+	if dryRun {
+		return nil
+	}
+
+	// Ensure directory exists
+	dir := filepath.Dir(filename)
+	err := os.MkdirAll(dir, 0700)
 	if err != nil {
-		fmt.Printf("Error determining config file path: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to create directory: %v", err)
 	}
 
-	// Read MFA secrets from file
-	entries, err := readSecrets(secretFile)
-	if err != nil || len(entries) == 0 {
-		// File doesn't exist or is empty
+	data, err := renderSecrets(filename, entries)
+	if err != nil {
+		return err
+	}
+	if passphrase := vaultPassphrases[absPath(filename)]; passphrase != nil {
+		// The file was (or is being) encrypted; keep it that way
+		data, err = encryptSecrets(data, passphrase)
 		if err != nil {
-			fmt.Printf("Secrets file not found or couldn't be read: %v\n", err)
-		} else {
-			fmt.Println("No MFA secrets found in the file.")
+			return fmt.Errorf("failed to encrypt secrets: %v", err)
 		}
+	}
 
-		// Ask user to input URL via command line
-		entries = promptForMFAUrl()
+	// Replace the target of a symlinked secrets file (e.g. one kept in a
+	// dotfiles repository) rather than the link itself
+	if target, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = target
+		dir = filepath.Dir(target)
+	}
 
-		// Save the URLs to the file for future use
-		if len(entries) > 0 {
-			err := saveSecrets(secretFile, entries)
-			if err != nil {
-				fmt.Printf("Warning: Failed to save secrets to %s: %v\n", secretFile, err)
+	// Write a temporary file (created 0600) and rename it into place, so a
+	// failed write never leaves a truncated secrets file behind
+	file, err := os.CreateTemp(dir, filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // Fails harmlessly once renamed
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// Warn that an export holds the secrets in plaintext, and exit unless
+// -confirm-plaintext (or -include-secrets) was given; flagName is the one
+// to suggest
+func confirmPlaintextExport(confirmed bool, flagName string) {
+	fmt.Fprintln(os.Stderr, styled(consoleBold, "WARNING: this export contains your MFA secrets in plaintext."))
+	if !confirmed {
+		fmt.Fprintf(os.Stderr, "Re-run with %s to print them.\n", flagName)
+		os.Exit(1)
+	}
+}
+
+// Print every entry of the secrets file as a full otpauth URL, passing
+// comments and blank lines through so the output can be imported as-is.
+// @include lines are replaced by the included file's entries.
+func exportSecretURLs(filename string) error {
+	return exportFileURLs(filename, nil)
+}
+
+// Export one secrets file for exportSecretURLs; including holds the files
+// already being exported, to catch include cycles
+func exportFileURLs(filename string, including []string) error {
+	data, err := readSecretsFile(filename)
+	if err != nil {
+		return err
+	}
+
+	including = append(including, absPath(filename))
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if include, ok := gmfa.ParseInclude(line); ok {
+			path := includePath(filename, include)
+			for _, seen := range including {
+				if seen == absPath(path) {
+					return fmt.Errorf("include cycle: %s includes %s, which is already being exported", filename, include)
+				}
+			}
+			if err := exportFileURLs(path, including); err != nil {
+				return fmt.Errorf("@include %s: %v", include, err)
 			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			fmt.Println(line)
+			continue
+		}
+
+		rawURL, comment := gmfa.SplitComment(line)
+		entry, err := gmfa.ParseOTPAuthURL(rawURL)
+		if err == nil {
+			entry, err = plaintextEntry(entry)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Skipping invalid MFA URL: %s (%v)\n", rawURL, err)
+			continue
+		}
+		if comment != "" {
+			fmt.Printf("%s # %s\n", gmfa.EntryURL(entry), comment)
 		} else {
-			fmt.Println("No valid MFA URLs provided. Exiting.")
-			os.Exit(1)
+			fmt.Println(gmfa.EntryURL(entry))
 		}
 	}
 
-	clearScreen()
-	fmt.Println("2FA TOTP Console Application")
-	fmt.Println("-----------------------------")
-	fmt.Printf("Loaded %d MFA entries from %s\n\n", len(entries), secretFile)
-
-	// Display codes immediately first
-	displayCodes(entries)
-
-	// Calculate wait time to align with the next code rotation
-	currentTime := time.Now().Unix()
-	secondsRemaining := timeStep - (currentTime % timeStep)
+	return scanner.Err()
+}
 
-	//fmt.Printf("\nNext code refresh in %d seconds\n", secondsRemaining)
-	time.Sleep(time.Duration(secondsRemaining) * time.Second)
+// Print every entry as one otpauth-migration URL for Google Authenticator
+func exportMigrationURL(filename string) error {
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
 
-	// Main loop to display codes at each rotation
-	for {
-		clearScreen()
-		displayCodes(entries)
-		time.Sleep(time.Duration(timeStep) * time.Second)
+	migrationURL, skipped := gmfa.MigrationURL(entries)
+	for _, err := range skipped {
+		fmt.Fprintf(os.Stderr, "Warning: Leaving out %v\n", err)
 	}
+	fmt.Println(migrationURL)
+	return nil
 }
 
-// Clear terminal screen based on OS
-func clearScreen() {
-	var cmd *exec.Cmd
+// Report whether two otpauth URLs generate identical codes. Labels and other
+// cosmetic parameters are ignored; the decoded secret and the code
+// parameters must match.
+func compareOTPAuthURLs(url1 string, url2 string) (bool, error) {
+	a, err := gmfa.ParseOTPAuthURL(url1)
+	if err != nil {
+		return false, fmt.Errorf("first URL: %v", err)
+	}
+	b, err := gmfa.ParseOTPAuthURL(url2)
+	if err != nil {
+		return false, fmt.Errorf("second URL: %v", err)
+	}
 
-	if runtime.GOOS == "windows" {
-		cmd = exec.Command("cmd", "/c", "cls")
-	} else {
-		// For Linux, macOS, etc.
-		cmd = exec.Command("clear")
+	var differences []string
+
+	secretA, errA := gmfa.DecodeSecret(a)
+	secretB, errB := gmfa.DecodeSecret(b)
+	if errA != nil || errB != nil || !bytes.Equal(secretA, secretB) {
+		differences = append(differences, "secret")
 	}
 
-	cmd.Stdout = os.Stdout
-	cmd.Run()
-}
+	if gmfa.IsHOTP(a) != gmfa.IsHOTP(b) {
+		differences = append(differences, "type")
+	}
+	if gmfa.AlgorithmName(a) != gmfa.AlgorithmName(b) {
+		differences = append(differences, fmt.Sprintf("algorithm (%s vs %s)", gmfa.AlgorithmName(a), gmfa.AlgorithmName(b)))
+	}
+
+	if gmfa.DigitCount(a) != gmfa.DigitCount(b) {
+		differences = append(differences, fmt.Sprintf("digits (%d vs %d)", gmfa.DigitCount(a), gmfa.DigitCount(b)))
+	}
 
-// Display current TOTP codes
-func displayCodes(entries []TOTPEntry) {
-	currentTime := time.Now().Unix()
-	validUntil := currentTime + (timeStep - (currentTime % timeStep))
+	if gmfa.EntryPeriod(a) != gmfa.EntryPeriod(b) {
+		differences = append(differences, fmt.Sprintf("period (%d vs %d)", gmfa.EntryPeriod(a), gmfa.EntryPeriod(b)))
+	}
 
-	fmt.Printf("\nTOTP Codes (valid until %s):\n", time.Unix(validUntil, 0).Format("15:04:05"))
-	fmt.Println("-----------------------------")
+	if a.Epoch != b.Epoch {
+		differences = append(differences, fmt.Sprintf("epoch (%d vs %d)", a.Epoch, b.Epoch))
+	}
 
-	for _, entry := range entries {
-		code := generateTOTP(entry.Secret, currentTime)
-		fmt.Printf(" * %-20s: %s%s%s\n", entry.Name, consoleBold, code, consoleReset)
+	if len(differences) > 0 {
+		fmt.Printf("Different tokens: %s differ\n", strings.Join(differences, ", "))
+		return false, nil
 	}
+
+	fmt.Printf("Same token: %s and %s generate identical codes\n", a.Name, b.Name)
+	return true, nil
 }
 
-// Get the full path to the config file in the user's home directory
-func getConfigFilePath() (string, error) {
-	homeDir, err := os.UserHomeDir()
+// Copy the current secrets file to a timestamped ".bak" sibling, e.g.
+// "gmfa.conf.20240102-150405.bak", before it is rewritten, and remove all
+// but the newest maxBackups of them
+func backupSecrets(filename string) error {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil // Nothing to back up yet
+	}
 	if err != nil {
-		return "", fmt.Errorf("could not determine home directory: %v", err)
+		return err
+	}
+
+	backup := fmt.Sprintf("%s.%s.bak", filename, time.Now().Format("20060102-150405"))
+	if err := os.WriteFile(backup, data, 0600); err != nil {
+		return err
 	}
 
-	return filepath.Join(homeDir, configFile), nil
+	// The timestamps sort oldest first
+	backups, err := filepath.Glob(filename + ".????????-??????.bak")
+	if err != nil {
+		return err
+	}
+	for len(backups) > maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
 }
 
-// Prompt user to enter MFA URLs via command line
-func promptForMFAUrl() []TOTPEntry {
-	var entries []TOTPEntry
-	scanner := bufio.NewScanner(os.Stdin)
+// Warn about backups of the secrets file that still hold secrets in
+// plaintext, as backups taken before it was encrypted or sealed do
+func warnPlaintextBackups(filename string) {
+	backups, _ := filepath.Glob(filename + ".*.bak")
+	for _, backup := range backups {
+		data, err := os.ReadFile(backup)
+		if err != nil || isEncrypted(data) {
+			continue
+		}
+		entries, _, _, _ := gmfa.ReadSecrets(bytes.NewReader(data))
+		for _, entry := range entries {
+			if entry.Sealed == "" {
+				fmt.Fprintf(os.Stderr, "Warning: the backup %s holds secrets in plaintext; delete it once you no longer need it\n", backup)
+				break
+			}
+		}
+	}
+}
 
-	fmt.Println("Please enter your MFA URL(s).")
-	fmt.Println("Format: otpauth://totp/Service:user@example.com?secret=ABCDEFGHIJKLMNOP&issuer=Service")
-	fmt.Println("Enter an empty line when finished.")
+// Merge the entries of importFile into the secrets file. Entries are matched
+// by name; when the secrets differ, policy decides whether to keep the
+// existing entry, replace it, or keep both. Unlike add and import, merge
+// never asks and keeps the existing entry unless told otherwise.
+func mergeSecrets(filename string, importFile string, policy string) error {
+	if policy == collisionAsk {
+		policy = collisionKeep
+	}
 
-	for {
-		fmt.Print("Enter MFA URL: ")
-		scanner.Scan()
-		input := strings.TrimSpace(scanner.Text())
+	unlock, err := lockSecrets(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-		if input == "" {
-			break // Empty line signals end of input
+	entries, err := readSecrets(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	incoming, err := readSecrets(importFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", importFile, err)
+	}
+
+	var added, replaced, kept, skipped int
+	for _, entry := range incoming {
+		entry.Include = "" // Merged entries are copied into the secrets file
+		existing := -1
+		for i := range entries {
+			if entries[i].Name == entry.Name {
+				existing = i
+				if entries[i].Secret == entry.Secret {
+					break
+				}
+			}
 		}
 
-		// Parse and validate the URL
-		entry, err := parseOTPAuthURL(input)
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
+		if existing < 0 {
+			entries = append(entries, entry)
+			added++
+			continue
+		}
+		if entries[existing].Secret == entry.Secret {
+			skipped++
 			continue
 		}
+		action, err := resolveNameCollision(entry, policy)
+		if err != nil {
+			return err
+		}
+		switch action {
+		case collisionReplace:
+			replaceSecret(&entries[existing], entry)
+			replaced++
+		case collisionBoth:
+			entries = append(entries, entry)
+			added++
+		default:
+			kept++
+		}
+	}
 
-		entries = append(entries, entry)
-		fmt.Printf("Added: %s\n", entry.Name)
+	fmt.Printf("Merge of %s: %d added, %d replaced, %d kept, %d skipped\n",
+		importFile, added, replaced, kept, skipped)
+
+	if added == 0 && replaced == 0 {
+		return nil
 	}
 
-	return entries
+	return saveSecrets(filename, entries)
 }
 
-// Parse an otpauth URL and return a TOTPEntry
-func parseOTPAuthURL(inputURL string) (TOTPEntry, error) {
-	u, err := url.Parse(inputURL)
+// Read MFA secrets from file, along with the files it includes
+func readSecrets(filename string) ([]gmfa.TOTPEntry, error) {
+	entries, err := loadSecrets(filename, nil)
 	if err != nil {
-		return TOTPEntry{}, fmt.Errorf("invalid URL format: %v", err)
+		return nil, err
 	}
 
-	if u.Scheme != "otpauth" || u.Host != "totp" {
-		return TOTPEntry{}, fmt.Errorf("URL must be an otpauth://totp URL")
+	entries, removed := dedupeEntries(entries)
+	if removed > 0 {
+		fmt.Fprintf(os.Stderr, "Skipping %d duplicate entries in %s\n", removed, filename)
 	}
-
-	path := strings.TrimPrefix(u.Path, "/")
-	query := u.Query()
-	secret := query.Get("secret")
-
-	if secret == "" {
-		return TOTPEntry{}, fmt.Errorf("missing 'secret' parameter in URL")
+	for _, entry := range entries {
+		if gmfa.ShortSecret(entry) {
+			fmt.Fprintf(os.Stderr, "Warning: %s has a suspiciously short secret; check it wasn't truncated\n", entry.Name)
+		}
+	}
+	for _, name := range nameConflicts(entries) {
+		fmt.Fprintf(os.Stderr, "Warning: Several entries named %q have different secrets\n", name)
+	}
+	for _, names := range secretCollisions(entries) {
+		fmt.Fprintf(os.Stderr, "Warning: %s share a secret and always show the same code\n", strings.Join(names, ", "))
 	}
 
-	return TOTPEntry{
-		Name:   path,
-		Secret: secret,
-	}, nil
+	return entries, nil
 }
 
-// Save MFA secrets to file
-func saveSecrets(filename string, entries []TOTPEntry) error {
-	// Ensure directory exists
-	dir := filepath.Dir(filename)
-	err := os.MkdirAll(dir, 0700)
+// Parse a secrets file and, recursively, the files it @includes, marking
+// each included entry with the file it came from. including holds the files
+// already being loaded, to catch include cycles.
+func loadSecrets(filename string, including []string) ([]gmfa.TOTPEntry, error) {
+	data, err := readSecretsFile(filename)
 	if err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
+		return nil, err
 	}
 
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	entries, includes, invalid, err := gmfa.ReadSecrets(bytes.NewReader(data))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for _, line := range invalid {
+		fmt.Fprintf(os.Stderr, "Warning: Skipping invalid MFA URL: %s (%v)\n", line.Text, line.Err)
 	}
-	defer file.Close()
+	unsealEntries(entries)
 
-	// Write header comments
-	file.WriteString("# GMFA Secrets File\n")
-	file.WriteString("# Format: otpauth://totp/Service:user@example.com?secret=ABCDEFGHIJKLMNOP&issuer=Service\n\n")
+	including = append(including, absPath(filename))
+	for _, include := range includes {
+		path := includePath(filename, include)
+		for _, seen := range including {
+			if seen == absPath(path) {
+				return nil, fmt.Errorf("include cycle: %s includes %s, which is already being loaded", filename, include)
+			}
+		}
 
-	// Write the URLs
-	for _, entry := range entries {
-		// Reconstruct a simplified URL
-		line := fmt.Sprintf("otpauth://totp/%s?secret=%s\n", entry.Name, entry.Secret)
-		file.WriteString(line)
+		included, err := loadSecrets(path, including)
+		if err != nil {
+			return nil, fmt.Errorf("@include %s: %v", include, err)
+		}
+		for i := range included {
+			if included[i].Include == "" {
+				included[i].Include = path
+			}
+		}
+		entries = append(entries, included...)
 	}
 
-	fmt.Printf("Saved %d MFA entries to %s\n", len(entries), filename)
-	return nil
+	return entries, nil
+}
+
+// Resolve an @include path: "~/" is the home directory, and relative paths
+// are relative to the including file's directory
+func includePath(filename string, include string) string {
+	if rest, ok := strings.CutPrefix(include, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	if filepath.IsAbs(include) {
+		return include
+	}
+	return filepath.Join(filepath.Dir(filename), include)
 }
 
-// Read MFA secrets from file
-func readSecrets(filename string) ([]TOTPEntry, error) {
-	var entries []TOTPEntry
+// Absolute form of path for comparing files, or path itself if that fails
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
 
-	file, err := os.Open(filename)
+// The @include paths of the secrets file as written, to carry over when it
+// is rewritten
+func secretsIncludes(filename string) ([]string, error) {
+	data, err := readSecretsFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue // Skip empty lines and comments
-		}
+	_, includes, _, err := gmfa.ReadSecrets(bytes.NewReader(data))
+	return includes, err
+}
 
-		// Parse otpauth URL
-		entry, err := parseOTPAuthURL(line)
-		if err != nil {
-			fmt.Printf("Warning: Skipping invalid MFA URL: %s (%v)\n", line, err)
+// Check that entries loaded from included files are still exactly as their
+// files hold them, since rewriting the including file can't save changes
+func checkIncludedEntries(filename string, entries []gmfa.TOTPEntry) error {
+	stored := make(map[string][]gmfa.TOTPEntry)
+	for _, entry := range entries {
+		if entry.Include == "" {
 			continue
 		}
 
-		entries = append(entries, entry)
+		if _, ok := stored[entry.Include]; !ok {
+			included, err := loadSecrets(entry.Include, nil)
+			if err != nil {
+				return err
+			}
+			stored[entry.Include] = included
+		}
+
+		unchanged := false
+		for _, original := range stored[entry.Include] {
+			if original.Include == "" && gmfa.EntryURL(original) == gmfa.EntryURL(entry) {
+				unchanged = true
+				break
+			}
+		}
+		if !unchanged {
+			return fmt.Errorf("%s comes from %s, included by %s; change it there (gmfa -config %s)", entry.Name, entry.Include, filename, entry.Include)
+		}
 	}
+	return nil
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+// Persist the next counter value for every HOTP entry among shown, whose
+// current codes have just been displayed
+func advanceHOTPCounters(filename string, shown []gmfa.TOTPEntry) error {
+	// Counters of included entries are saved in their own files
+	hasHOTP := false
+	included := make(map[string][]gmfa.TOTPEntry)
+	for _, entry := range shown {
+		if !gmfa.IsHOTP(entry) {
+			continue
+		}
+		if entry.Include != "" {
+			path := entry.Include
+			entry.Include = ""
+			included[path] = append(included[path], entry)
+			continue
+		}
+		hasHOTP = true
+	}
+	for path, entries := range included {
+		if err := advanceHOTPCounters(path, entries); err != nil {
+			return err
+		}
+	}
+	if !hasHOTP {
+		return nil
 	}
 
-	return entries, nil
-}
+	unlock, err := lockSecrets(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-// Generate TOTP code
-func generateTOTP(secret string, timestamp int64) string {
-	// Decode the base32 secret
-	secretBytes, err := base32.StdEncoding.DecodeString(strings.ToUpper(secret))
+	entries, err := readSecrets(filename)
 	if err != nil {
-		return "ERROR"
+		return err
 	}
 
-	// Calculate the counter value (number of time steps since Unix epoch)
-	counter := timestamp / timeStep
+	for i := range entries {
+		for _, entry := range shown {
+			if gmfa.IsHOTP(entry) && entry.Include == "" && entries[i].Include == "" &&
+				entries[i].Name == entry.Name && entries[i].Secret == entry.Secret {
+				entries[i].Counter = entry.Counter + 1
+			}
+		}
+	}
 
-	// Generate HMAC-SHA1
-	counterBytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(counterBytes, uint64(counter))
+	return writeSecrets(filename, entries)
+}
 
-	mac := hmac.New(sha1.New, secretBytes)
-	mac.Write(counterBytes)
-	hash := mac.Sum(nil)
+// Print the current code for a secret given on the command line ("-" reads
+// it from stdin), cleaned up like a pasted secret and never saved
+func printSecretCode(secret string, name string) error {
+	if secret == "-" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("no secret on stdin")
+		}
+		secret = line
+	}
 
-	// Dynamic truncation
-	offset := hash[len(hash)-1] & 0x0F
-	truncatedHash := binary.BigEndian.Uint32(hash[offset:offset+4]) & 0x7FFFFFFF
+	label := name
+	if label == "" {
+		label = "secret"
+	}
+	entry, err := gmfa.ParseOTPAuthURL("otpauth://totp/" + url.PathEscape(label) + "?secret=" + url.QueryEscape(strings.TrimSpace(secret)))
+	if err != nil {
+		return err
+	}
 
-	// Generate code with the required number of digits
-	code := truncatedHash % uint32(pow10(codeDigits))
-	return fmt.Sprintf("%0*d", codeDigits, code)
+	code, err := gmfa.GenerateTOTP(entry, now())
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		fmt.Printf("%s: %s\n", name, code)
+	} else {
+		fmt.Println(code)
+	}
+	return nil
 }
 
-// Helper function to calculate 10^n
-func pow10(n int) int {
-	result := 1
-	for i := 0; i < n; i++ {
-		result *= 10
+// Print the HMAC input for an entry's current time step, for cross-checking
+// other TOTP implementations
+func printCounterBytes(filename string, name string, timestamp int64) error {
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+
+	i, err := findEntry(entries, name)
+	if err != nil {
+		return err
 	}
-	return result
+
+	counterBytes := gmfa.CounterBytes(entries[i], time.Unix(timestamp, 0))
+	fmt.Printf("%s: timestamp=%d counter=%d bytes=%s\n", entries[i].Name, timestamp,
+		binary.BigEndian.Uint64(counterBytes), hex.EncodeToString(counterBytes))
+	return nil
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+)
+
+func TestFindEntry(t *testing.T) {
+	entries := []gmfa.TOTPEntry{
+		{Name: "GitHub", Secret: "JBSWY3DPEHPK3PXP"},
+		{Name: "GitHub:work", Secret: "GEZDGNBVGY3TQOJQ"},
+		{Name: "AWS:root", Secret: "MFRGGZDFMZTWQ2LK"},
+		{Name: "AWS:dev", Secret: "KRUGKIDROVUWG2ZA"},
+		{Name: "Slack", Secret: "JBSWY3DPEHPK3PXP"},
+		{Name: "slack", Secret: "GEZDGNBVGY3TQOJQ"},
+	}
+
+	tests := []struct {
+		name    string
+		want    int
+		wantErr string
+	}{
+		{"github", 0, ""}, // Exact beats the substring match of GitHub:work
+		{"work", 1, ""},   // Unique substring
+		{"AWS", -1, "matches multiple entries"},
+		{"nothing", -1, "no entry matches"},
+		{"Slack", -1, "names 2 entries"}, // Same name twice
+	}
+	for _, tt := range tests {
+		got, err := findEntry(entries, tt.name)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("findEntry(%q) error = %v, want one containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("findEntry(%q) = %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// Run f with os.Stdout going to a temporary file and return what it printed
+func captureStdout(t *testing.T, f func() error) string {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	stdout := os.Stdout
+	os.Stdout = file
+	err = f()
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Exported URLs import back into the same entries, comments included, with
+// the entries of included files in place of the @include line
+func TestExportImportRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "gmfa.conf")
+	content := "@include work.conf\n\n" +
+		"# Personal account\n" +
+		"# recovery codes in the safe\n" +
+		"otpauth://totp/GitHub:me?secret=JBSWY3DPEHPK3PXP&issuer=GitHub&tags=dev\n" +
+		"otpauth://hotp/Bank?secret=GEZDGNBVGY3TQOJQ&counter=4 # the old token\n" +
+		"otpauth://totp/Plain?secret=MFRGGZDFMZTWQ2LK\n"
+	work := "# Work SSO\notpauth://totp/ACME:me?secret=KRUGKIDROVUWG2ZA&issuer=ACME\n"
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "work.conf"), []byte(work), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := readSecrets(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := captureStdout(t, func() error { return exportSecretURLs(filename) })
+	got, err := readImportLines(strings.NewReader(exported))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("imported %d entries, want %d; export:\n%s", len(got), len(want), exported)
+	}
+	// Included entries are loaded last but exported in place
+	imported := make(map[string]gmfa.TOTPEntry)
+	for _, entry := range got {
+		imported[entry.Name] = entry
+	}
+	for _, entry := range want {
+		entry.Include = "" // Imported entries belong to the importing file
+		if gmfa.EntryURL(imported[entry.Name]) != gmfa.EntryURL(entry) || imported[entry.Name].Comment != entry.Comment {
+			t.Errorf("%s imported as %s (comment %q), want %s (comment %q)", entry.Name,
+				gmfa.EntryURL(imported[entry.Name]), imported[entry.Name].Comment, gmfa.EntryURL(entry), entry.Comment)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// How long to wait for an NTP server to answer
+const ntpTimeout = 3 * time.Second
+
+// Seconds between the NTP epoch (1900) and the Unix epoch (1970)
+const ntpEpochOffset = 2208988800
+
+// Ask an NTP server how far the local clock is off, using a single SNTP
+// exchange (RFC 4330). A positive offset means the local clock is behind.
+func ntpOffset(server string) (time.Duration, error) {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(server, "123"), ntpTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ntpTimeout))
+
+	// LI 0, version 4, mode 3 (client)
+	request := make([]byte, 48)
+	request[0] = 0<<6 | 4<<3 | 3
+
+	sent := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+
+	response := make([]byte, 48)
+	n, err := conn.Read(response)
+	received := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if n < 48 || response[0]&7 != 4 {
+		return 0, fmt.Errorf("malformed reply from %s", server)
+	}
+	if response[1] == 0 {
+		return 0, fmt.Errorf("%s sent a kiss-of-death reply", server)
+	}
+
+	serverReceived := ntpTime(response[32:40])
+	serverSent := ntpTime(response[40:48])
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+// Decode a 64-bit NTP timestamp: seconds since 1900 and a binary fraction
+func ntpTime(b []byte) time.Time {
+	seconds := int64(binary.BigEndian.Uint32(b[0:4])) - ntpEpochOffset
+	fraction := int64(binary.BigEndian.Uint32(b[4:8]))
+	return time.Unix(seconds, fraction*int64(time.Second)>>32)
+}
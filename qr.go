@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+	"golang.org/x/term"
+	"rsc.io/qr"
+)
+
+// Blank modules around the QR code so scanners can find its edges
+const qrQuietZone = 2
+
+// Print the provisioning URL of the entry matching name as a QR code, or
+// as plain text when the terminal is too narrow to draw it
+func printQR(filename string, name string) error {
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+
+	i, err := findEntry(entries, name)
+	if err != nil {
+		return err
+	}
+
+	entry, err := plaintextEntry(entries[i])
+	if err != nil {
+		return err
+	}
+	provisioningURL, err := gmfa.ProvisioningURL(entry)
+	if err != nil {
+		return fmt.Errorf("%s: %v", entry.Name, err)
+	}
+	if entry.Epoch != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %s counts time steps from a custom epoch, which authenticator apps don't support; their codes will differ\n", entry.Name)
+	}
+
+	code, err := qr.Encode(provisioningURL, qr.M)
+	if err != nil {
+		return fmt.Errorf("failed to encode QR code: %v", err)
+	}
+
+	width := code.Size + 2*qrQuietZone
+	if columns, _, err := term.GetSize(int(os.Stdout.Fd())); err != nil || columns < width {
+		fmt.Println("Terminal too narrow for the QR code; provisioning URL:")
+		fmt.Println(provisioningURL)
+		return nil
+	}
+
+	fmt.Print(renderQR(code))
+	fmt.Println(displayName(entry))
+	return nil
+}
+
+// Draw a QR code with half-block characters, two rows of modules per line.
+// Dark modules are drawn as blanks on a light background so the code scans
+// on dark terminal themes.
+func renderQR(code *qr.Code) string {
+	light := func(x, y int) bool {
+		return !code.Black(x, y) // Outside the code is the light quiet zone
+	}
+
+	var out strings.Builder
+	for y := -qrQuietZone; y < code.Size+qrQuietZone; y += 2 {
+		for x := -qrQuietZone; x < code.Size+qrQuietZone; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				out.WriteString("█")
+			case top:
+				out.WriteString("▀")
+			case bottom:
+				out.WriteString("▄")
+			default:
+				out.WriteString(" ")
+			}
+		}
+		out.WriteString("\n")
+	}
+	return out.String()
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+)
+
+// Individual secrets can be sealed as "secret=enc:" followed by unpadded
+// URL-safe base64(nonce || AES-256-GCM ciphertext of the secret), with a
+// 32-byte key kept in a key file of its own. Names and issuers stay
+// readable, so the secrets file can live in a dotfiles repository.
+
+// Environment variable naming the key file, when -key-file isn't given
+const keyFileEnv = "GMFA_KEY_FILE"
+
+// Size of the key in a key file
+const sealKeySize = 32
+
+// Path of the key file for sealed secrets, set with -key-file
+var keyFile string
+
+// Key loaded from the key file on first use
+var sealKey []byte
+
+// The key file path from -key-file or $GMFA_KEY_FILE, or "" if neither
+func keyFilePath() string {
+	if keyFile != "" {
+		return keyFile
+	}
+	return os.Getenv(keyFileEnv)
+}
+
+// Load the key for sealed secrets, once
+func loadSealKey() ([]byte, error) {
+	if sealKey != nil {
+		return sealKey, nil
+	}
+
+	path := keyFilePath()
+	if path == "" {
+		return nil, fmt.Errorf("no key file to decrypt it with; set -key-file or $%s", keyFileEnv)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+	defer clear(data)
+
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(key) != sealKeySize {
+		clear(key)
+		return nil, fmt.Errorf("%s is not a gmfa key file (want %d base64-encoded bytes)", path, sealKeySize)
+	}
+	sealKey = key
+	return sealKey, nil
+}
+
+// Create a key file holding a new random key, refusing to overwrite one
+func createKeyFile(path string) error {
+	key := make([]byte, sealKeySize)
+	defer clear(key)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Encrypt a secret into its "enc:" form
+func sealSecret(key []byte, secret string) (string, error) {
+	gcm, err := newSealCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return gmfa.SealedPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt an "enc:" secret
+func unsealSecret(key []byte, sealed string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(sealed, gmfa.SealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("corrupted encrypted secret: %v", err)
+	}
+
+	gcm, err := newSealCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("corrupted encrypted secret: too short")
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	secret, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("wrong key file or corrupted encrypted secret")
+	}
+	return string(secret), nil
+}
+
+func newSealCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Decrypt a sealed entry's secret in place; plaintext entries are left alone
+func unsealEntry(entry *gmfa.TOTPEntry) error {
+	if entry.Sealed == "" || entry.Secret != "" {
+		return nil
+	}
+	key, err := loadSealKey()
+	if err != nil {
+		return err
+	}
+	secret, err := unsealSecret(key, entry.Sealed)
+	if err != nil {
+		return err
+	}
+	return gmfa.SetSecret(entry, secret)
+}
+
+// Decrypt every sealed secret, warning about the ones that can't be. Those
+// entries stay loaded but can't generate codes, while the rest work as
+// usual.
+func unsealEntries(entries []gmfa.TOTPEntry) {
+	for i := range entries {
+		if err := unsealEntry(&entries[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s: can't decrypt its secret: %v\n", entries[i].Name, err)
+		}
+	}
+}
+
+// The entry with its secret in plaintext, for output meant for another
+// authenticator, like export and QR codes
+func plaintextEntry(entry gmfa.TOTPEntry) (gmfa.TOTPEntry, error) {
+	if err := unsealEntry(&entry); err != nil {
+		return entry, fmt.Errorf("%s: can't decrypt its secret: %v", entry.Name, err)
+	}
+	entry.Sealed = ""
+	return entry, nil
+}
+
+// Encrypt every plaintext secret in the secrets file with the key file,
+// creating the key file first if it doesn't exist yet
+func sealSecrets(filename string) error {
+	path := keyFilePath()
+	if path == "" {
+		return fmt.Errorf("no key file given; set -key-file or $%s (it is created if missing)", keyFileEnv)
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) && dryRun {
+		// Seal with a throwaway key to show the result
+		sealKey = make([]byte, sealKeySize)
+		if _, err := rand.Read(sealKey); err != nil {
+			return err
+		}
+		fmt.Printf("Dry run: would create key file %s\n", path)
+	} else if os.IsNotExist(err) {
+		if err := createKeyFile(path); err != nil {
+			return fmt.Errorf("failed to create key file: %v", err)
+		}
+		fmt.Printf("Created key file %s; keep a copy somewhere safe, the secrets can't be decrypted without it\n", path)
+	}
+	key, err := loadSealKey()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockSecrets(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+
+	sealed := 0
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Sealed != "" || entry.Include != "" {
+			continue // Included entries are sealed in their own file
+		}
+		entry.Sealed, err = sealSecret(key, entry.Secret)
+		if err != nil {
+			return err
+		}
+		sealed++
+	}
+	if sealed == 0 {
+		fmt.Printf("Every secret in %s is already encrypted\n", filename)
+		return nil
+	}
+
+	// A backup would keep the plaintext secrets next to the sealed file
+	fmt.Printf("Encrypted the secrets of %d entries\n", sealed)
+	if err := saveSecretsBackup(filename, entries, false); err != nil {
+		return err
+	}
+	if !dryRun {
+		warnPlaintextBackups(filename)
+	}
+	return nil
+}
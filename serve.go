@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+)
+
+// HTTP server for -serve: GET /code/{name} returns an entry's current code
+// as JSON and GET /healthz reports liveness
+type codeServer struct {
+	filename string
+	token    string
+
+	mu      sync.Mutex // Guards entries, whose HOTP counters advance
+	entries []gmfa.TOTPEntry
+}
+
+// Serve codes over HTTP on addr until the server fails
+func runServer(addr string, filename string, token string) error {
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no MFA entries loaded from %s", filename)
+	}
+
+	server := &codeServer{filename: filename, token: token, entries: entries}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /code/{name}", server.handleCode)
+
+	if token == "" {
+		fmt.Println("Warning: serving codes without -token; anyone who can reach the server can read them")
+	}
+	fmt.Printf("Serving %d MFA entries on %s\n", len(entries), addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// Respond with the current code of the entry matching the name in the path
+func (s *codeServer) handleCode(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		given := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(given, []byte("Bearer "+s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or wrong bearer token"})
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := findEntry(s.entries, r.PathValue("name"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+
+	item := newJSONCode(s.entries[i], now().Unix())
+	if gmfa.IsHOTP(s.entries[i]) {
+		if err := advanceHOTPCounters(s.filename, s.entries[i:i+1]); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save HOTP counter: " + err.Error()})
+			return
+		}
+		s.entries[i].Counter++
+	}
+
+	status := http.StatusOK
+	if item.Error != "" {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, item)
+}
+
+// Write value as a JSON response
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
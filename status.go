@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+)
+
+// Default -format of "gmfa status"
+const defaultStatusFormat = "{code} ({remaining}s)"
+
+// Print one line for a status bar (tmux, polybar, ...) with the current
+// code of the entry matching name, filled into format's {code},
+// {remaining} and {name} placeholders. HOTP entries are refused, since a
+// status bar polling them would use up their codes.
+func printStatus(filename string, name string, format string) error {
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+
+	i, err := findEntry(entries, name)
+	if err != nil {
+		return err
+	}
+	entry := entries[i]
+	if gmfa.IsHOTP(entry) {
+		return fmt.Errorf("%s is an HOTP entry, whose codes are used up when shown", entry.Name)
+	}
+
+	currentTime := now().Unix()
+	code, err := gmfa.GenerateTOTP(entry, time.Unix(currentTime, 0))
+	if err != nil {
+		return fmt.Errorf("%s: %v", entry.Name, err)
+	}
+
+	fmt.Println(strings.NewReplacer(
+		"{code}", code,
+		"{remaining}", strconv.FormatInt(codeValidUntil(entry, currentTime)-currentTime, 10),
+		"{name}", entry.Name,
+	).Replace(format))
+	return nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrintStatusAmbiguous(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "gmfa.conf")
+	content := "otpauth://totp/GitHub?secret=JBSWY3DPEHPK3PXP\n" +
+		"otpauth://totp/GitHub?secret=GEZDGNBVGY3TQOJQ\n"
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := printStatus(filename, "github", defaultStatusFormat)
+	if err == nil || !strings.Contains(err.Error(), "names 2 entries") {
+		t.Errorf("printStatus with two entries named GitHub: error = %v, want an ambiguity error", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+)
+
+// Check a code against the entry matching name. TOTP codes are accepted
+// from up to window time steps either side of now; HOTP codes from the
+// stored counter up to window steps ahead, resynchronizing on a match.
+func verifyCode(filename string, name string, code string, window int) (bool, error) {
+	if window < 0 {
+		return false, fmt.Errorf("window must not be negative")
+	}
+
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return false, err
+	}
+
+	i, err := findEntry(entries, name)
+	if err != nil {
+		return false, err
+	}
+	entry := entries[i]
+	code = strings.Join(strings.Fields(code), "")
+	if gmfa.IsSteam(entry) {
+		code = strings.ToUpper(code)
+	}
+
+	if gmfa.IsHOTP(entry) {
+		for step := 0; step <= window; step++ {
+			candidate := entry
+			candidate.Counter += uint64(step)
+			expected, err := gmfa.GenerateTOTP(candidate, now())
+			if err != nil {
+				return false, err
+			}
+			if expected == code {
+				fmt.Printf("Match: code is valid for %s (counter %d)\n", entry.Name, candidate.Counter)
+				return true, advanceHOTPCounters(filename, []gmfa.TOTPEntry{candidate})
+			}
+		}
+		fmt.Printf("Mismatch: code is not valid for %s within %d counter steps\n", entry.Name, window)
+		return false, nil
+	}
+
+	currentTime := now().Unix()
+	period := gmfa.EntryPeriod(entry)
+	for _, step := range driftSteps(window) {
+		expected, err := gmfa.GenerateTOTP(entry, time.Unix(currentTime+int64(step)*period, 0))
+		if err != nil {
+			return false, err
+		}
+		if expected == code {
+			fmt.Printf("Match: code is valid for %s (%s)\n", entry.Name, describeStep(step))
+			return true, nil
+		}
+	}
+
+	fmt.Printf("Mismatch: code is not valid for %s within ±%d time steps\n", entry.Name, window)
+	return false, nil
+}
+
+// Time step offsets to try, nearest first: 0, -1, +1, -2, +2, ...
+func driftSteps(window int) []int {
+	steps := []int{0}
+	for i := 1; i <= window; i++ {
+		steps = append(steps, -i, i)
+	}
+	return steps
+}
+
+// Describe a time step offset relative to now
+func describeStep(step int) string {
+	switch {
+	case step == 0:
+		return "current time step"
+	case step == -1:
+		return "1 step behind"
+	case step < 0:
+		return fmt.Sprintf("%d steps behind", -step)
+	case step == 1:
+		return "1 step ahead"
+	default:
+		return fmt.Sprintf("%d steps ahead", step)
+	}
+}
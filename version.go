@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set with -ldflags "-X main.version=... -X main.commit=...
+// -X main.date=..."
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// Print the version, commit and build date. Builds without -ldflags fall
+// back to the VCS information the Go toolchain embeds.
+func printVersion() {
+	revision, built, modified := commit, date, false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if revision == "" {
+					revision = setting.Value
+				}
+			case "vcs.time":
+				if built == "" {
+					built = setting.Value
+				}
+			case "vcs.modified":
+				modified = commit == "" && setting.Value == "true"
+			}
+		}
+	}
+
+	if revision == "" {
+		revision = "unknown"
+	} else if modified {
+		revision += "-dirty"
+	}
+	if built == "" {
+		built = "unknown"
+	}
+	fmt.Printf("gmfa %s (commit %s, built %s, %s %s/%s)\n", version, revision, built, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nealhardesty/gmfa/gmfa"
+	"golang.org/x/term"
+)
+
+// ANSI escape codes to hide and show the cursor
+const (
+	consoleHideCursor = "\033[?25l"
+	consoleShowCursor = "\033[?25h"
+)
+
+// Digit glyphs for the large -watch display, three columns by five rows;
+// each column is drawn two characters wide
+var bigDigits = [10][5]string{
+	{"###", "# #", "# #", "# #", "###"},
+	{"  #", "  #", "  #", "  #", "  #"},
+	{"###", "  #", "###", "#  ", "###"},
+	{"###", "  #", "###", "  #", "###"},
+	{"# #", "# #", "###", "  #", "  #"},
+	{"###", "#  ", "###", "  #", "###"},
+	{"###", "#  ", "###", "# #", "###"},
+	{"###", "  #", "  #", "  #", "  #"},
+	{"###", "# #", "###", "# #", "###"},
+	{"###", "# #", "###", "  #", "###"},
+}
+
+// Take over the terminal to show one entry's code in large type with a
+// countdown, until a key is pressed
+func runWatch(filename string, name string) error {
+	entries, err := readSecrets(filename)
+	if err != nil {
+		return err
+	}
+
+	entry, err := chooseEntry(entries, name)
+	if err != nil {
+		return err
+	}
+
+	keys, restore := startKeys()
+	defer restore()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	fmt.Print(consoleHideCursor)
+	defer fmt.Print(consoleClear + consoleShowCursor)
+
+	if gmfa.IsHOTP(entry) {
+		// Showing an HOTP code uses it up
+		if err := advanceHOTPCounters(filename, []gmfa.TOTPEntry{entry}); err != nil {
+			return fmt.Errorf("failed to save HOTP counter: %v", err)
+		}
+	}
+
+	for {
+		currentTime := now()
+		drawWatch(entry, currentTime.Unix())
+
+		select {
+		case <-keys:
+			return nil
+		case <-signals:
+			return nil
+		case <-time.After(time.Unix(currentTime.Unix()+1, 0).Sub(currentTime)):
+		}
+	}
+}
+
+// Pick the entry for name: an exact or unique match, or else one chosen
+// from a numbered list of the candidates
+func chooseEntry(entries []gmfa.TOTPEntry, name string) (gmfa.TOTPEntry, error) {
+	if i, err := findEntry(entries, name); err == nil {
+		return entries[i], nil
+	}
+
+	matches := filterEntries(entries, name)
+	if len(matches) == 0 {
+		return gmfa.TOTPEntry{}, fmt.Errorf("no entry matches %q", name)
+	}
+
+	for i, entry := range matches {
+		fmt.Printf("%2d) %s\n", i+1, displayName(entry))
+	}
+	fmt.Print("Select entry: ")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		return gmfa.TOTPEntry{}, fmt.Errorf("no entry selected")
+	}
+	choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || choice < 1 || choice > len(matches) {
+		return gmfa.TOTPEntry{}, fmt.Errorf("invalid selection %q", scanner.Text())
+	}
+	return matches[choice-1], nil
+}
+
+// Draw one frame of the -watch display, centered in the terminal
+func drawWatch(entry gmfa.TOTPEntry, currentTime int64) {
+	columns, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		columns, rows = 80, 24
+	}
+
+	code, err := gmfa.GenerateTOTP(entry, time.Unix(currentTime, 0))
+	if err != nil {
+		code = invalidCode
+	}
+	lines := bigText(code)
+
+	fmt.Print(consoleClear)
+	top := max(1, (rows-len(lines))/2-2)
+	printCentered(top, columns, consoleBold, displayName(entry))
+	for i, line := range lines {
+		printCentered(top+2+i, columns, consoleBold, line)
+	}
+
+	status := fmt.Sprintf("counter %d", entry.Counter)
+	if !gmfa.IsHOTP(entry) {
+		period := gmfa.EntryPeriod(entry)
+		remaining := codeValidUntil(entry, currentTime) - currentTime
+		width := min(60, max(10, columns-10))
+		filled := int(remaining * int64(width) / period)
+		status = strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + fmt.Sprintf(" %2ds", remaining)
+	}
+	printCentered(top+len(lines)+3, columns, "", status)
+	printCentered(rows, columns, consoleDim, "Press any key to exit")
+}
+
+// Render a code in large block digits; codes with other characters are
+// just spaced out
+func bigText(code string) []string {
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return []string{strings.Join(strings.Split(code, ""), " ")}
+		}
+	}
+
+	lines := make([]string, 5)
+	for i := range lines {
+		var glyphs []string
+		for _, c := range code {
+			glyph := bigDigits[c-'0'][i]
+			glyph = strings.ReplaceAll(strings.ReplaceAll(glyph, "#", "██"), " ", "  ")
+			glyphs = append(glyphs, glyph)
+		}
+		lines[i] = strings.Join(glyphs, "  ")
+	}
+	return lines
+}
+
+// Print text at row, horizontally centered, in the given style
+func printCentered(row int, columns int, style string, text string) {
+	column := max(1, (columns-displayWidth(text))/2+1)
+	if style != "" {
+		text = styled(style, text)
+	}
+	fmt.Printf("\033[%d;%dH%s", row, column, text)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Ranges of East Asian wide and fullwidth characters, which terminals draw
+// two columns wide
+var wideRanges = []struct{ lo, hi rune }{
+	{0x1100, 0x115F},   // Hangul Jamo initials
+	{0x2E80, 0x303E},   // CJK radicals, Kangxi, CJK symbols and punctuation
+	{0x3041, 0x33FF},   // Hiragana, Katakana, Bopomofo, CJK compatibility
+	{0x3400, 0x4DBF},   // CJK unified ideographs extension A
+	{0x4E00, 0x9FFF},   // CJK unified ideographs
+	{0xA000, 0xA4CF},   // Yi
+	{0xAC00, 0xD7A3},   // Hangul syllables
+	{0xF900, 0xFAFF},   // CJK compatibility ideographs
+	{0xFE30, 0xFE4F},   // CJK compatibility forms
+	{0xFF00, 0xFF60},   // Fullwidth forms
+	{0xFFE0, 0xFFE6},   // Fullwidth signs
+	{0x1F300, 0x1F64F}, // Pictographs and emoticons
+	{0x1F900, 0x1F9FF}, // Supplemental pictographs
+	{0x20000, 0x2FFFD}, // CJK unified ideographs extensions B onwards
+	{0x30000, 0x3FFFD},
+}
+
+// Number of terminal columns s takes up: wide characters count twice,
+// combining marks and format characters not at all, and ANSI escape
+// sequences are skipped
+func displayWidth(s string) int {
+	width := 0
+	escape := false
+	for _, r := range s {
+		switch {
+		case escape:
+			// An escape sequence ends with its first letter
+			escape = !unicode.IsLetter(r)
+		case r == '\033':
+			escape = true
+		case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf, unicode.Cc):
+		case isWide(r):
+			width += 2
+		default:
+			width++
+		}
+	}
+	return width
+}
+
+func isWide(r rune) bool {
+	for _, wide := range wideRanges {
+		if r >= wide.lo && r <= wide.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// Pad s with spaces to fill width terminal columns
+func padRight(s string, width int) string {
+	return s + strings.Repeat(" ", max(0, width-displayWidth(s)))
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+	}{
+		{"GitHub:alice", 12},
+		{"Caf\u00e9:user@例え.jp", 17},  // Precomposed é, two wide characters
+		{"Cafe\u0301:user@例え.jp", 17}, // e with a combining acute accent
+		{"例え (太郎)", 11},
+		{"\033[1m\033[32m123456\033[0m", 6}, // Escape sequences take no room
+		{"", 0},
+	}
+	for _, test := range tests {
+		if width := displayWidth(test.text); width != test.width {
+			t.Errorf("displayWidth(%q) = %d, want %d", test.text, width, test.width)
+		}
+	}
+}
+
+func TestPadRight(t *testing.T) {
+	padded := padRight("Café:user@例え.jp", 20)
+	if want := "Café:user@例え.jp   "; padded != want {
+		t.Errorf("padRight = %q, want %q", padded, want)
+	}
+	if padded := padRight("much too long", 4); padded != "much too long" {
+		t.Errorf("padRight of a longer string = %q, want it unchanged", padded)
+	}
+}